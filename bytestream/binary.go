@@ -0,0 +1,366 @@
+package bytestream
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// The Write* methods return ErrTooLarge, writing nothing, if the data would
+// exceed the maximum size of the stream.
+
+func (bs *ByteStream) WriteUint8(x uint8) error {
+	return bs.WriteByte(x)
+}
+
+func (bs *ByteStream) WriteInt8(x int8) error {
+	return bs.WriteByte(uint8(x))
+}
+
+func (bs *ByteStream) WriteUint16(x uint16, byteOrder binary.ByteOrder) error {
+	if err := bs.TryReserveBuffer(2); err != nil {
+		return err
+	}
+
+	byteOrder.PutUint16(bs.GetBuffer(), x)
+	bs.doCommitBuffer(2)
+	return nil
+}
+
+func (bs *ByteStream) WriteUint32(x uint32, byteOrder binary.ByteOrder) error {
+	if err := bs.TryReserveBuffer(4); err != nil {
+		return err
+	}
+
+	byteOrder.PutUint32(bs.GetBuffer(), x)
+	bs.doCommitBuffer(4)
+	return nil
+}
+
+func (bs *ByteStream) WriteUint64(x uint64, byteOrder binary.ByteOrder) error {
+	if err := bs.TryReserveBuffer(8); err != nil {
+		return err
+	}
+
+	byteOrder.PutUint64(bs.GetBuffer(), x)
+	bs.doCommitBuffer(8)
+	return nil
+}
+
+func (bs *ByteStream) WriteInt16(x int16, byteOrder binary.ByteOrder) error {
+	return bs.WriteUint16(uint16(x), byteOrder)
+}
+
+func (bs *ByteStream) WriteInt32(x int32, byteOrder binary.ByteOrder) error {
+	return bs.WriteUint32(uint32(x), byteOrder)
+}
+
+func (bs *ByteStream) WriteInt64(x int64, byteOrder binary.ByteOrder) error {
+	return bs.WriteUint64(uint64(x), byteOrder)
+}
+
+func (bs *ByteStream) WriteFloat32(x float32, byteOrder binary.ByteOrder) error {
+	return bs.WriteUint32(math.Float32bits(x), byteOrder)
+}
+
+func (bs *ByteStream) WriteFloat64(x float64, byteOrder binary.ByteOrder) error {
+	return bs.WriteUint64(math.Float64bits(x), byteOrder)
+}
+
+func (bs *ByteStream) WriteUvarint(x uint64) error {
+	var buffer [binary.MaxVarintLen64]byte
+	return bs.TryWrite(buffer[:binary.PutUvarint(buffer[:], x)])
+}
+
+func (bs *ByteStream) WriteVarint(x int64) error {
+	var buffer [binary.MaxVarintLen64]byte
+	return bs.TryWrite(buffer[:binary.PutVarint(buffer[:], x)])
+}
+
+// WriteLengthPrefixedBytes writes the data prefixed with its size as a uvarint.
+func (bs *ByteStream) WriteLengthPrefixedBytes(data []byte) error {
+	prefixSize, err := bs.writeSizePrefix(len(data))
+
+	if err != nil {
+		return err
+	}
+
+	if err := bs.TryWrite(data); err != nil {
+		bs.Unwrite(prefixSize)
+		return err
+	}
+
+	return nil
+}
+
+func (bs *ByteStream) WriteLengthPrefixedString(s string) error {
+	prefixSize, err := bs.writeSizePrefix(len(s))
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := bs.WriteString(s); err != nil {
+		bs.Unwrite(prefixSize)
+		return err
+	}
+
+	return nil
+}
+
+// The Peek* methods leave the data in the stream; the Read* methods consume
+// it. Both return ErrShortData, consuming nothing, if the stream does not
+// hold enough data.
+
+func (bs *ByteStream) PeekUint8() (uint8, error) {
+	data, err := bs.peek(1)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return data[0], nil
+}
+
+func (bs *ByteStream) ReadUint8() (uint8, error) {
+	x, err := bs.PeekUint8()
+	bs.skipIfNoError(1, err)
+	return x, err
+}
+
+func (bs *ByteStream) PeekInt8() (int8, error) {
+	x, err := bs.PeekUint8()
+	return int8(x), err
+}
+
+func (bs *ByteStream) ReadInt8() (int8, error) {
+	x, err := bs.ReadUint8()
+	return int8(x), err
+}
+
+func (bs *ByteStream) PeekUint16(byteOrder binary.ByteOrder) (uint16, error) {
+	data, err := bs.peek(2)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return byteOrder.Uint16(data), nil
+}
+
+func (bs *ByteStream) ReadUint16(byteOrder binary.ByteOrder) (uint16, error) {
+	x, err := bs.PeekUint16(byteOrder)
+	bs.skipIfNoError(2, err)
+	return x, err
+}
+
+func (bs *ByteStream) PeekUint32(byteOrder binary.ByteOrder) (uint32, error) {
+	data, err := bs.peek(4)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return byteOrder.Uint32(data), nil
+}
+
+func (bs *ByteStream) ReadUint32(byteOrder binary.ByteOrder) (uint32, error) {
+	x, err := bs.PeekUint32(byteOrder)
+	bs.skipIfNoError(4, err)
+	return x, err
+}
+
+func (bs *ByteStream) PeekUint64(byteOrder binary.ByteOrder) (uint64, error) {
+	data, err := bs.peek(8)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return byteOrder.Uint64(data), nil
+}
+
+func (bs *ByteStream) ReadUint64(byteOrder binary.ByteOrder) (uint64, error) {
+	x, err := bs.PeekUint64(byteOrder)
+	bs.skipIfNoError(8, err)
+	return x, err
+}
+
+func (bs *ByteStream) PeekInt16(byteOrder binary.ByteOrder) (int16, error) {
+	x, err := bs.PeekUint16(byteOrder)
+	return int16(x), err
+}
+
+func (bs *ByteStream) ReadInt16(byteOrder binary.ByteOrder) (int16, error) {
+	x, err := bs.ReadUint16(byteOrder)
+	return int16(x), err
+}
+
+func (bs *ByteStream) PeekInt32(byteOrder binary.ByteOrder) (int32, error) {
+	x, err := bs.PeekUint32(byteOrder)
+	return int32(x), err
+}
+
+func (bs *ByteStream) ReadInt32(byteOrder binary.ByteOrder) (int32, error) {
+	x, err := bs.ReadUint32(byteOrder)
+	return int32(x), err
+}
+
+func (bs *ByteStream) PeekInt64(byteOrder binary.ByteOrder) (int64, error) {
+	x, err := bs.PeekUint64(byteOrder)
+	return int64(x), err
+}
+
+func (bs *ByteStream) ReadInt64(byteOrder binary.ByteOrder) (int64, error) {
+	x, err := bs.ReadUint64(byteOrder)
+	return int64(x), err
+}
+
+func (bs *ByteStream) PeekFloat32(byteOrder binary.ByteOrder) (float32, error) {
+	x, err := bs.PeekUint32(byteOrder)
+	return math.Float32frombits(x), err
+}
+
+func (bs *ByteStream) ReadFloat32(byteOrder binary.ByteOrder) (float32, error) {
+	x, err := bs.ReadUint32(byteOrder)
+	return math.Float32frombits(x), err
+}
+
+func (bs *ByteStream) PeekFloat64(byteOrder binary.ByteOrder) (float64, error) {
+	x, err := bs.PeekUint64(byteOrder)
+	return math.Float64frombits(x), err
+}
+
+func (bs *ByteStream) ReadFloat64(byteOrder binary.ByteOrder) (float64, error) {
+	x, err := bs.ReadUint64(byteOrder)
+	return math.Float64frombits(x), err
+}
+
+func (bs *ByteStream) PeekUvarint() (uint64, error) {
+	x, _, err := bs.peekUvarint()
+	return x, err
+}
+
+func (bs *ByteStream) ReadUvarint() (uint64, error) {
+	x, n, err := bs.peekUvarint()
+	bs.skipIfNoError(n, err)
+	return x, err
+}
+
+func (bs *ByteStream) PeekVarint() (int64, error) {
+	x, _, err := bs.peekVarint()
+	return x, err
+}
+
+func (bs *ByteStream) ReadVarint() (int64, error) {
+	x, n, err := bs.peekVarint()
+	bs.skipIfNoError(n, err)
+	return x, err
+}
+
+// PeekLengthPrefixedBytes returns data referring to the internal buffer,
+// which is only valid until the stream is modified.
+func (bs *ByteStream) PeekLengthPrefixedBytes() ([]byte, error) {
+	data, _, err := bs.peekLengthPrefixedBytes()
+	return data, err
+}
+
+func (bs *ByteStream) ReadLengthPrefixedBytes() ([]byte, error) {
+	data, n, err := bs.peekLengthPrefixedBytes()
+
+	if err != nil {
+		return nil, err
+	}
+
+	data = append([]byte(nil), data...)
+	bs.doSkip(n)
+	return data, nil
+}
+
+func (bs *ByteStream) PeekLengthPrefixedString() (string, error) {
+	data, _, err := bs.peekLengthPrefixedBytes()
+	return string(data), err
+}
+
+func (bs *ByteStream) ReadLengthPrefixedString() (string, error) {
+	data, n, err := bs.peekLengthPrefixedBytes()
+
+	if err != nil {
+		return "", err
+	}
+
+	s := string(data)
+	bs.doSkip(n)
+	return s, nil
+}
+
+func (bs *ByteStream) peek(dataSize int) ([]byte, error) {
+	data := bs.GetData()
+
+	if len(data) < dataSize {
+		return nil, ErrShortData
+	}
+
+	return data[:dataSize], nil
+}
+
+func (bs *ByteStream) peekUvarint() (uint64, int, error) {
+	x, n := binary.Uvarint(bs.GetData())
+
+	if n == 0 {
+		return 0, 0, ErrShortData
+	}
+
+	if n < 0 {
+		return 0, 0, ErrVarintOverflow
+	}
+
+	return x, n, nil
+}
+
+func (bs *ByteStream) peekVarint() (int64, int, error) {
+	x, n := binary.Varint(bs.GetData())
+
+	if n == 0 {
+		return 0, 0, ErrShortData
+	}
+
+	if n < 0 {
+		return 0, 0, ErrVarintOverflow
+	}
+
+	return x, n, nil
+}
+
+func (bs *ByteStream) peekLengthPrefixedBytes() ([]byte, int, error) {
+	dataSize, n, err := bs.peekUvarint()
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	data := bs.GetData()[n:]
+
+	if dataSize > uint64(len(data)) {
+		return nil, 0, ErrShortData
+	}
+
+	return data[:dataSize], n + int(dataSize), nil
+}
+
+func (bs *ByteStream) writeSizePrefix(size int) (int, error) {
+	var buffer [binary.MaxVarintLen64]byte
+	prefixSize := binary.PutUvarint(buffer[:], uint64(size))
+	return prefixSize, bs.TryWrite(buffer[:prefixSize])
+}
+
+func (bs *ByteStream) skipIfNoError(dataSize int, err error) {
+	if err == nil {
+		bs.doSkip(dataSize)
+	}
+}
+
+var (
+	ErrShortData      = errors.New("toolkit/bytestream: short data")
+	ErrVarintOverflow = errors.New("toolkit/bytestream: varint overflow")
+)
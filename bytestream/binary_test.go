@@ -0,0 +1,178 @@
+package bytestream
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func TestBinary(t *testing.T) {
+	for _, byteOrder := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		var bs ByteStream
+		bs.WriteUint8(0xFE)
+		bs.WriteInt8(-2)
+		bs.WriteUint16(0xABCD, byteOrder)
+		bs.WriteInt16(-3, byteOrder)
+		bs.WriteUint32(0xDEADBEEF, byteOrder)
+		bs.WriteInt32(math.MinInt32, byteOrder)
+		bs.WriteUint64(math.MaxUint64-1, byteOrder)
+		bs.WriteInt64(math.MinInt64, byteOrder)
+		bs.WriteFloat32(1.5, byteOrder)
+		bs.WriteFloat64(-math.Pi, byteOrder)
+		bs.WriteUvarint(300)
+		bs.WriteVarint(-300)
+		bs.WriteLengthPrefixedBytes([]byte("bytes"))
+		bs.WriteLengthPrefixedString("string")
+
+		if x, err := bs.PeekUint8(); x != 0xFE || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadUint8(); x != 0xFE || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadInt8(); x != -2 || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.PeekUint16(byteOrder); x != 0xABCD || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadUint16(byteOrder); x != 0xABCD || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadInt16(byteOrder); x != -3 || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadUint32(byteOrder); x != 0xDEADBEEF || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadInt32(byteOrder); x != math.MinInt32 || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadUint64(byteOrder); x != math.MaxUint64-1 || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadInt64(byteOrder); x != math.MinInt64 || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadFloat32(byteOrder); x != 1.5 || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadFloat64(byteOrder); x != -math.Pi || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.PeekUvarint(); x != 300 || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadUvarint(); x != 300 || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadVarint(); x != -300 || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.PeekLengthPrefixedBytes(); string(x) != "bytes" || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadLengthPrefixedBytes(); string(x) != "bytes" || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if x, err := bs.ReadLengthPrefixedString(); x != "string" || err != nil {
+			t.Errorf("%#v %#v", x, err)
+		}
+
+		if dsz := bs.GetDataSize(); dsz != 0 {
+			t.Errorf("%#v", dsz)
+		}
+	}
+}
+
+func TestBinaryMaxSize(t *testing.T) {
+	bs := new(ByteStream).Init(Options{MaxSize: 8})
+	bs.Write(make([]byte, 6))
+
+	if err := bs.WriteUint32(1, binary.BigEndian); err != ErrTooLarge {
+		t.Errorf("%#v", err)
+	}
+
+	if err := bs.WriteLengthPrefixedString("ab"); err != ErrTooLarge {
+		t.Errorf("%#v", err)
+	}
+
+	if err := bs.WriteLengthPrefixedBytes([]byte("ab")); err != ErrTooLarge {
+		t.Errorf("%#v", err)
+	}
+
+	if dsz := bs.GetDataSize(); dsz != 6 {
+		t.Errorf("%#v", dsz)
+	}
+
+	if err := bs.WriteLengthPrefixedString("a"); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := bs.WriteUvarint(300); err != ErrTooLarge {
+		t.Errorf("%#v", err)
+	}
+
+	if err := bs.WriteUint8(1); err != ErrTooLarge {
+		t.Errorf("%#v", err)
+	}
+
+	if dsz := bs.GetDataSize(); dsz != 8 {
+		t.Errorf("%#v", dsz)
+	}
+}
+
+func TestBinaryShortData(t *testing.T) {
+	var bs ByteStream
+	bs.Write([]byte{5, 2, 3})
+
+	if _, err := bs.ReadUint32(binary.BigEndian); err != ErrShortData {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := bs.PeekUint64(binary.LittleEndian); err != ErrShortData {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := bs.ReadLengthPrefixedBytes(); err != ErrShortData {
+		t.Errorf("%#v", err)
+	}
+
+	if dsz := bs.GetDataSize(); dsz != 3 {
+		t.Errorf("%#v", dsz)
+	}
+
+	bs.Skip(3)
+	bs.Write([]byte{0x80, 0x80})
+
+	if _, err := bs.ReadUvarint(); err != ErrShortData {
+		t.Errorf("%#v", err)
+	}
+
+	bs.Write([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80})
+
+	if _, err := bs.ReadUvarint(); err != ErrVarintOverflow {
+		t.Errorf("%#v", err)
+	}
+
+	if dsz := bs.GetDataSize(); dsz != 11 {
+		t.Errorf("%#v", dsz)
+	}
+}
@@ -0,0 +1,58 @@
+package bytestream
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// BufferPool provides the backing arrays of byte streams. The sizes asked for
+// are powers of two, unless capped by the MaxSize option of a stream. GetBuffer
+// must return a slice of exactly the given length.
+type BufferPool interface {
+	GetBuffer(size int) []byte
+	PutBuffer(buffer []byte)
+}
+
+var DefaultBufferPool BufferPool = new(SizeClassBufferPool)
+
+// SizeClassBufferPool keeps a sync.Pool for each power of two. Buffers are
+// pooled through pointers, whose holders are pooled as well, so that neither
+// GetBuffer nor PutBuffer allocates in the steady state.
+type SizeClassBufferPool struct {
+	sizeClasses   [bits.UintSize]sync.Pool
+	bufferHolders sync.Pool
+}
+
+func (scbp *SizeClassBufferPool) GetBuffer(size int) []byte {
+	sizeClass := bits.Len(uint(size - 1))
+
+	if size < 1 || sizeClass >= len(scbp.sizeClasses) {
+		return make([]byte, size)
+	}
+
+	if bufferHolder, ok := scbp.sizeClasses[sizeClass].Get().(*[]byte); ok {
+		buffer := *bufferHolder
+		*bufferHolder = nil
+		scbp.bufferHolders.Put(bufferHolder)
+		return buffer[:size]
+	}
+
+	return make([]byte, size, 1<<uint(sizeClass))
+}
+
+func (scbp *SizeClassBufferPool) PutBuffer(buffer []byte) {
+	bufferSize := cap(buffer)
+
+	if bufferSize < 1 || bufferSize&(bufferSize-1) != 0 {
+		return
+	}
+
+	bufferHolder, ok := scbp.bufferHolders.Get().(*[]byte)
+
+	if !ok {
+		bufferHolder = new([]byte)
+	}
+
+	*bufferHolder = buffer[:bufferSize]
+	scbp.sizeClasses[bits.Len(uint(bufferSize-1))].Put(bufferHolder)
+}
@@ -0,0 +1,77 @@
+package bytestream
+
+import (
+	"testing"
+)
+
+func TestSizeClassBufferPool(t *testing.T) {
+	var bp SizeClassBufferPool
+
+	for _, size := range []int{1, 2, 3, 1000, 1024, 1025} {
+		buffer := bp.GetBuffer(size)
+
+		if len(buffer) != size || cap(buffer)&(cap(buffer)-1) != 0 {
+			t.Errorf("%#v %#v %#v", size, len(buffer), cap(buffer))
+		}
+
+		bp.PutBuffer(buffer)
+	}
+
+	bp.PutBuffer(make([]byte, 100))
+
+	if buffer := bp.GetBuffer(100); len(buffer) != 100 || cap(buffer) != 128 {
+		t.Errorf("%#v %#v", len(buffer), cap(buffer))
+	}
+
+	// sync.Pool drops some buffers on purpose when the race detector is enabled
+	if n := testing.AllocsPerRun(1000, func() { bp.PutBuffer(bp.GetBuffer(100)) }); n >= 1 {
+		t.Errorf("%#v", n)
+	}
+}
+
+func TestByteStreamBufferPool(t *testing.T) {
+	var bp countingBufferPool
+	bs := new(ByteStream).Init(Options{BufferPool: &bp})
+	bs.Write([]byte("hello"))
+	bs.ReserveBuffer(100)
+	bs.Expand()
+	bs.Shrink(0)
+
+	if d := bs.GetData(); string(d) != "hello" {
+		t.Errorf("%#v", d)
+	}
+
+	if bp.NumberOfGets != 4 || bp.NumberOfPuts != 3 {
+		t.Errorf("%#v", bp)
+	}
+
+	bs.Release()
+
+	if bp.NumberOfGets != 4 || bp.NumberOfPuts != 4 {
+		t.Errorf("%#v", bp)
+	}
+
+	if sz := bs.Size(); sz != 0 {
+		t.Errorf("%#v", sz)
+	}
+
+	bs.Write([]byte("world"))
+
+	if d := bs.GetData(); string(d) != "world" {
+		t.Errorf("%#v", d)
+	}
+}
+
+type countingBufferPool struct {
+	NumberOfGets int
+	NumberOfPuts int
+}
+
+func (cbp *countingBufferPool) GetBuffer(size int) []byte {
+	cbp.NumberOfGets++
+	return make([]byte, size)
+}
+
+func (cbp *countingBufferPool) PutBuffer([]byte) {
+	cbp.NumberOfPuts++
+}
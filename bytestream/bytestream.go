@@ -1,13 +1,61 @@
 package bytestream
 
 import (
+	"errors"
+	"io"
+
 	"github.com/let-z-go/toolkit/utils"
 )
 
+type Options struct {
+	BufferPool   BufferPool
+	MaxSize      int
+	ShrinkPolicy ShrinkPolicy
+}
+
+func (o *Options) normalize() {
+	if o.BufferPool == nil {
+		o.BufferPool = DefaultBufferPool
+	}
+
+	if o.MaxSize < 0 {
+		o.MaxSize = 0
+	}
+}
+
+// ShrinkPolicy is consulted whenever data is consumed from the stream. It is
+// given the current size and data size of the stream and returns the size to
+// shrink the stream down to, or any size not less than the current size to
+// leave the stream as is.
+type ShrinkPolicy func(size int, dataSize int) int
+
+// ShrinkWhenEmpty returns a ShrinkPolicy which shrinks an empty stream grown
+// beyond the given size back down to it.
+func ShrinkWhenEmpty(maxIdleSize int) ShrinkPolicy {
+	return func(size int, dataSize int) int {
+		if dataSize == 0 && size > maxIdleSize {
+			return maxIdleSize
+		}
+
+		return size
+	}
+}
+
+// ByteStream is ready to use without Init, in which case it uses the default
+// options.
 type ByteStream struct {
-	base         []byte
-	dataOffset   int
-	bufferOffset int
+	options              Options
+	base                 []byte
+	dataOffset           int
+	bufferOffset         int
+	lastByte             byte
+	lastByteIsUnreadable bool
+}
+
+func (bs *ByteStream) Init(options Options) *ByteStream {
+	options.normalize()
+	bs.options = options
+	return bs
 }
 
 func (bs *ByteStream) Read(buffer []byte) int {
@@ -27,15 +75,133 @@ func (bs *ByteStream) Skip(dataSize int) int {
 	return dataSize
 }
 
+// Write panics with ErrTooLarge if the data cannot be written without exceeding
+// the maximum size; TryWrite returns the error instead.
+//
+// Growing the stream returns the old backing array to the buffer pool, so
+// slices from GetData or GetBuffer must not be kept across a write.
 func (bs *ByteStream) Write(data []byte) {
 	bs.ReserveBuffer(len(data))
 	copy(bs.GetBuffer(), data)
 	bs.doCommitBuffer(len(data))
 }
 
+func (bs *ByteStream) TryWrite(data []byte) error {
+	if err := bs.TryReserveBuffer(len(data)); err != nil {
+		return err
+	}
+
+	copy(bs.GetBuffer(), data)
+	bs.doCommitBuffer(len(data))
+	return nil
+}
+
+func (bs *ByteStream) WriteByte(c byte) error {
+	if err := bs.TryReserveBuffer(1); err != nil {
+		return err
+	}
+
+	bs.base[bs.bufferOffset] = c
+	bs.doCommitBuffer(1)
+	return nil
+}
+
+func (bs *ByteStream) WriteString(s string) (int, error) {
+	if err := bs.TryReserveBuffer(len(s)); err != nil {
+		return 0, err
+	}
+
+	copy(bs.GetBuffer(), s)
+	bs.doCommitBuffer(len(s))
+	return len(s), nil
+}
+
+func (bs *ByteStream) ReadByte() (byte, error) {
+	if bs.GetDataSize() == 0 {
+		return 0, io.EOF
+	}
+
+	c := bs.base[bs.dataOffset]
+	bs.doSkip(1)
+	bs.lastByte = c
+	bs.lastByteIsUnreadable = true
+	return c, nil
+}
+
+// UnreadByte puts back the byte returned by the last call to ReadByte, provided
+// no other data has been read since. It may fail with ErrTooLarge if the
+// stream is full.
+func (bs *ByteStream) UnreadByte() error {
+	if !bs.lastByteIsUnreadable {
+		return ErrInvalidUnreadByte
+	}
+
+	if bs.dataOffset >= 1 {
+		bs.dataOffset--
+	} else {
+		if err := bs.TryReserveBuffer(1); err != nil {
+			return err
+		}
+
+		copy(bs.base[1:], bs.GetData())
+		bs.doCommitBuffer(1)
+	}
+
+	bs.lastByteIsUnreadable = false
+	bs.base[bs.dataOffset] = bs.lastByte
+	return nil
+}
+
+// ReadFrom reads from the given reader straight into the buffer, which grows as
+// needed, until io.EOF or until the stream reaches its maximum size.
+func (bs *ByteStream) ReadFrom(reader io.Reader) (int64, error) {
+	dataSize := int64(0)
+
+	for {
+		bufferSize := minReadSize
+
+		if maxSize := bs.options.MaxSize; maxSize >= 1 && bufferSize > maxSize-bs.GetDataSize() {
+			if bufferSize = maxSize - bs.GetDataSize(); bufferSize == 0 {
+				return dataSize, ErrTooLarge
+			}
+		}
+
+		bs.ReserveBuffer(bufferSize)
+		n, err := reader.Read(bs.GetBuffer())
+		dataSize += int64(bs.CommitBuffer(n))
+
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+
+			return dataSize, err
+		}
+	}
+}
+
+func (bs *ByteStream) WriteTo(writer io.Writer) (int64, error) {
+	n, err := writer.Write(bs.GetData())
+	n = bs.Skip(n)
+
+	if err == nil && bs.GetDataSize() >= 1 {
+		err = io.ErrShortWrite
+	}
+
+	return int64(n), err
+}
+
+// ReadWriter returns a view of the stream implementing io.Reader and io.Writer.
+func (bs *ByteStream) ReadWriter() *ReadWriter {
+	return (*ReadWriter)(bs)
+}
+
 func (bs *ByteStream) WriteDirectly(bufferSize int, callback func([]byte) error) error {
 	bufferSize = int(utils.MaxOfZero(int64(bufferSize)))
-	bs.ReserveBuffer(bufferSize)
+
+	if err := bs.TryReserveBuffer(bufferSize); err != nil {
+		return err
+	}
 
 	if err := callback(bs.GetBuffer()); err != nil {
 		return err
@@ -61,24 +227,39 @@ func (bs *ByteStream) Unwrite(dataSize int) int {
 	return dataSize
 }
 
+// ReserveBuffer panics with ErrTooLarge if the buffer cannot be reserved
+// without exceeding the maximum size; TryReserveBuffer returns the error
+// instead.
 func (bs *ByteStream) ReserveBuffer(bufferSize int) {
+	if err := bs.TryReserveBuffer(bufferSize); err != nil {
+		panic(err)
+	}
+}
+
+func (bs *ByteStream) TryReserveBuffer(bufferSize int) error {
 	bufferSize = int(utils.MaxOfZero(int64(bufferSize)))
 
 	if bs.GetBufferSize() >= bufferSize {
-		return
+		return nil
 	}
 
 	data := bs.GetData()
 
+	if maxSize := bs.options.MaxSize; maxSize >= 1 && bufferSize > maxSize-len(data) {
+		return ErrTooLarge
+	}
+
 	if len(bs.base)-len(data) < bufferSize {
-		bs.base = make([]byte, int(utils.NextPowerOfTwo(int64(len(data)+bufferSize))))
+		bs.resize(bs.limitSize(int(utils.NextPowerOfTwo(int64(len(data) + bufferSize)))))
 	} else {
-		if len(data)*2 > len(bs.base) {
-			bs.base = make([]byte, 2*len(bs.base))
+		if newSize := bs.limitSize(2 * len(bs.base)); len(data)*2 > len(bs.base) && newSize > len(bs.base) {
+			bs.resize(newSize)
+		} else {
+			bs.setData(data)
 		}
 	}
 
-	bs.setData(data)
+	return nil
 }
 
 func (bs *ByteStream) CommitBuffer(bufferSize int) int {
@@ -93,10 +274,9 @@ func (bs *ByteStream) CommitBuffer(bufferSize int) int {
 }
 
 func (bs *ByteStream) Expand() {
-	data := bs.GetData()
-	newSize := len(bs.base) * 2
-	bs.base = make([]byte, newSize)
-	bs.setData(data)
+	if newSize := bs.limitSize(len(bs.base) * 2); newSize > len(bs.base) {
+		bs.resize(newSize)
+	}
 }
 
 func (bs *ByteStream) Shrink(minSize int) {
@@ -106,16 +286,30 @@ func (bs *ByteStream) Shrink(minSize int) {
 		minSize = len(data)
 	}
 
-	minSize = int(utils.NextPowerOfTwo(int64(minSize)))
+	minSize = bs.limitSize(int(utils.NextPowerOfTwo(int64(minSize))))
 
 	if len(bs.base) == minSize {
 		return
 	}
 
-	bs.base = make([]byte, minSize)
-	bs.setData(data)
+	bs.resize(minSize)
+}
+
+// Release gives the buffer back to the buffer pool and empties the stream.
+func (bs *ByteStream) Release() {
+	if bs.base != nil {
+		bs.getBufferPool().PutBuffer(bs.base)
+	}
+
+	bs.base = nil
+	bs.dataOffset = 0
+	bs.bufferOffset = 0
+	bs.lastByteIsUnreadable = false
 }
 
+// GetData returns the data referring to the backing array, which is only valid
+// until the stream is modified: once the stream grows or shrinks, the array is
+// returned to the buffer pool and may be overwritten by another stream.
 func (bs *ByteStream) GetData() []byte {
 	return bs.base[bs.dataOffset:bs.bufferOffset]
 }
@@ -124,6 +318,7 @@ func (bs *ByteStream) GetDataSize() int {
 	return bs.bufferOffset - bs.dataOffset
 }
 
+// GetBuffer is subject to the same restriction as GetData.
 func (bs *ByteStream) GetBuffer() []byte {
 	return bs.base[bs.bufferOffset:]
 }
@@ -138,10 +333,49 @@ func (bs *ByteStream) Size() int {
 
 func (bs *ByteStream) doSkip(dataSize int) {
 	bs.dataOffset += dataSize
+	bs.lastByteIsUnreadable = false
 
 	if bs.dataOffset*2 >= bs.bufferOffset {
 		bs.setData(bs.GetData())
 	}
+
+	if shrinkPolicy := bs.options.ShrinkPolicy; shrinkPolicy != nil {
+		if newSize := shrinkPolicy(len(bs.base), bs.GetDataSize()); newSize < len(bs.base) {
+			bs.Shrink(newSize)
+		}
+	}
+}
+
+func (bs *ByteStream) resize(size int) {
+	var base []byte
+
+	if size >= 1 {
+		base = bs.getBufferPool().GetBuffer(size)
+	}
+
+	oldBase := bs.base
+	bs.base = base
+	bs.setData(oldBase[bs.dataOffset:bs.bufferOffset])
+
+	if oldBase != nil {
+		bs.getBufferPool().PutBuffer(oldBase)
+	}
+}
+
+func (bs *ByteStream) limitSize(size int) int {
+	if maxSize := bs.options.MaxSize; maxSize >= 1 && size > maxSize {
+		return maxSize
+	}
+
+	return size
+}
+
+func (bs *ByteStream) getBufferPool() BufferPool {
+	if bs.options.BufferPool == nil {
+		return DefaultBufferPool
+	}
+
+	return bs.options.BufferPool
 }
 
 func (bs *ByteStream) setData(data []byte) {
@@ -153,3 +387,58 @@ func (bs *ByteStream) setData(data []byte) {
 func (bs *ByteStream) doCommitBuffer(bufferSize int) {
 	bs.bufferOffset += bufferSize
 }
+
+type ReadWriter ByteStream
+
+func (rw *ReadWriter) Read(buffer []byte) (int, error) {
+	bs := rw.ByteStream()
+
+	if bs.GetDataSize() == 0 && len(buffer) >= 1 {
+		return 0, io.EOF
+	}
+
+	return bs.Read(buffer), nil
+}
+
+func (rw *ReadWriter) Write(data []byte) (int, error) {
+	if err := rw.ByteStream().TryWrite(data); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (rw *ReadWriter) ReadFrom(reader io.Reader) (int64, error) {
+	return rw.ByteStream().ReadFrom(reader)
+}
+
+func (rw *ReadWriter) WriteTo(writer io.Writer) (int64, error) {
+	return rw.ByteStream().WriteTo(writer)
+}
+
+func (rw *ReadWriter) ReadByte() (byte, error) {
+	return rw.ByteStream().ReadByte()
+}
+
+func (rw *ReadWriter) UnreadByte() error {
+	return rw.ByteStream().UnreadByte()
+}
+
+func (rw *ReadWriter) WriteByte(c byte) error {
+	return rw.ByteStream().WriteByte(c)
+}
+
+func (rw *ReadWriter) WriteString(s string) (int, error) {
+	return rw.ByteStream().WriteString(s)
+}
+
+func (rw *ReadWriter) ByteStream() *ByteStream {
+	return (*ByteStream)(rw)
+}
+
+var (
+	ErrInvalidUnreadByte = errors.New("toolkit/bytestream: invalid unread byte")
+	ErrTooLarge          = errors.New("toolkit/bytestream: too large")
+)
+
+const minReadSize = 512
@@ -1,7 +1,10 @@
 package bytestream
 
 import (
+	"bufio"
 	"bytes"
+	"io"
+	"strings"
 	"testing"
 )
 
@@ -89,3 +92,185 @@ func TestReadWriteByteStream3(t *testing.T) {
 		t.Errorf("%#v", bufsz)
 	}
 }
+
+func TestReadFromWriteTo(t *testing.T) {
+	var bs ByteStream
+	data := strings.Repeat("0123456789", 1000)
+	bs.WriteString("x")
+
+	if n, err := io.Copy(bs.ReadWriter(), strings.NewReader(data)); n != int64(len(data)) || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if d := bs.GetData(); string(d) != "x"+data {
+		t.Errorf("%#v", len(d))
+	}
+
+	var buffer bytes.Buffer
+
+	if n, err := io.Copy(&buffer, bs.ReadWriter()); n != int64(len(data)+1) || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if s := buffer.String(); s != "x"+data {
+		t.Errorf("%#v", len(s))
+	}
+
+	if dsz := bs.GetDataSize(); dsz != 0 {
+		t.Errorf("%#v", dsz)
+	}
+
+	bs.ReadWriter().Write([]byte("hello\nworld\n"))
+	r := bufio.NewReader(bs.ReadWriter())
+
+	if s, err := r.ReadString('\n'); s != "hello\n" || err != nil {
+		t.Errorf("%#v %#v", s, err)
+	}
+
+	if s, err := r.ReadString('\n'); s != "world\n" || err != nil {
+		t.Errorf("%#v %#v", s, err)
+	}
+
+	if _, err := r.ReadString('\n'); err != io.EOF {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestReadByteUnreadByte(t *testing.T) {
+	var bs ByteStream
+
+	if err := bs.UnreadByte(); err != ErrInvalidUnreadByte {
+		t.Errorf("%#v", err)
+	}
+
+	bs.WriteString("abcd")
+	bs.WriteByte('e')
+
+	for i, c := range []byte("abcde") {
+		if c2, err := bs.ReadByte(); c2 != c || err != nil {
+			t.Errorf("%#v %#v %#v", i, c2, err)
+		}
+
+		if err := bs.UnreadByte(); err != nil {
+			t.Errorf("%#v %#v", i, err)
+		}
+
+		if err := bs.UnreadByte(); err != ErrInvalidUnreadByte {
+			t.Errorf("%#v %#v", i, err)
+		}
+
+		if d := bs.GetData(); string(d) != "abcde"[i:] {
+			t.Errorf("%#v %#v", i, string(d))
+		}
+
+		bs.ReadByte()
+	}
+
+	if _, err := bs.ReadByte(); err != io.EOF {
+		t.Errorf("%#v", err)
+	}
+
+	bs.WriteString("fg")
+	bs.ReadByte()
+	bs.Skip(1)
+
+	if err := bs.UnreadByte(); err != ErrInvalidUnreadByte {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	bs := new(ByteStream).Init(Options{MaxSize: 100})
+
+	if err := bs.TryWrite(make([]byte, 60)); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := bs.TryWrite(make([]byte, 41)); err != ErrTooLarge {
+		t.Errorf("%#v", err)
+	}
+
+	if err := bs.WriteDirectly(41, func([]byte) error { return nil }); err != ErrTooLarge {
+		t.Errorf("%#v", err)
+	}
+
+	if err := bs.TryReserveBuffer(40); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if sz := bs.Size(); sz != 100 {
+		t.Errorf("%#v", sz)
+	}
+
+	bs.Expand()
+
+	if sz := bs.Size(); sz != 100 {
+		t.Errorf("%#v", sz)
+	}
+
+	if n, err := bs.ReadFrom(strings.NewReader(strings.Repeat("x", 50))); n != 40 || err != ErrTooLarge {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if err := bs.WriteByte('x'); err != ErrTooLarge {
+		t.Errorf("%#v", err)
+	}
+
+	if dsz := bs.GetDataSize(); dsz != 100 {
+		t.Errorf("%#v", dsz)
+	}
+
+	if n, err := bs.ReadWriter().Write([]byte("x")); n != 0 || err != ErrTooLarge {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	bs2 := new(ByteStream).Init(Options{MaxSize: 1})
+	bs2.WriteByte('a')
+	bs2.ReadByte()
+	bs2.WriteByte('b')
+
+	if err := bs2.UnreadByte(); err != ErrTooLarge {
+		t.Errorf("%#v", err)
+	}
+
+	if d := bs2.GetData(); string(d) != "b" {
+		t.Errorf("%#v", d)
+	}
+
+	defer func() {
+		if r := recover(); r != ErrTooLarge {
+			t.Errorf("%#v", r)
+		}
+	}()
+
+	bs.Write([]byte("x"))
+}
+
+func TestShrinkPolicy(t *testing.T) {
+	bs := new(ByteStream).Init(Options{ShrinkPolicy: ShrinkWhenEmpty(64)})
+	bs.Write(make([]byte, 1000))
+
+	if sz := bs.Size(); sz != 1024 {
+		t.Errorf("%#v", sz)
+	}
+
+	bs.Skip(999)
+
+	if sz := bs.Size(); sz != 1024 {
+		t.Errorf("%#v", sz)
+	}
+
+	bs.ReadByte()
+
+	if sz := bs.Size(); sz != 64 {
+		t.Errorf("%#v", sz)
+	}
+
+	if err := bs.UnreadByte(); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if dsz := bs.GetDataSize(); dsz != 1 {
+		t.Errorf("%#v", dsz)
+	}
+}
@@ -0,0 +1,279 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/let-z-go/toolkit/bytestream"
+	"github.com/let-z-go/toolkit/condition"
+)
+
+type BufferedWriterOptions struct {
+	FlushThreshold int
+	MaxLatency     time.Duration
+	FlushTimeout   time.Duration
+}
+
+func (bwo *BufferedWriterOptions) normalize() {
+	if bwo.FlushThreshold < 1 {
+		bwo.FlushThreshold = defaultFlushThreshold
+	}
+
+	if bwo.MaxLatency <= 0 {
+		bwo.MaxLatency = defaultMaxLatency
+	}
+
+	if bwo.FlushTimeout <= 0 {
+		bwo.FlushTimeout = defaultFlushTimeout
+	}
+}
+
+// BufferedWriter coalesces small writes. Errors from the connection stick,
+// except for timeouts and context errors, which leave the unwritten data
+// buffered so that a later write or flush can retry.
+type BufferedWriter struct {
+	connection     *Connection
+	options        BufferedWriterOptions
+	lock           sync.Mutex
+	stream         bytestream.ByteStream
+	flushingStream bytestream.ByteStream
+	flushCondition condition.Condition
+	timer          *time.Timer
+	timerIsSet     bool
+	isFlushing     bool
+	isStopped      bool
+	err            error
+}
+
+func (bw *BufferedWriter) Init(connection *Connection, options BufferedWriterOptions) *BufferedWriter {
+	options.normalize()
+	bw.connection = connection
+	bw.options = options
+	bw.flushCondition.Init(&bw.lock)
+	bw.timer = time.AfterFunc(time.Hour, bw.onTimer)
+	bw.timer.Stop()
+	return bw
+}
+
+func (bw *BufferedWriter) Write(ctx context.Context, deadline time.Time, data []byte) (int, error) {
+	bw.lock.Lock()
+	defer bw.lock.Unlock()
+
+	if bw.err != nil {
+		return 0, bw.err
+	}
+
+	if bw.stream.GetDataSize()+len(data) < bw.options.FlushThreshold {
+		bw.stream.Write(data)
+		bw.setTimer()
+		return len(data), nil
+	}
+
+	if err := bw.waitForBackgroundFlush(ctx, deadline); err != nil {
+		return 0, err
+	}
+
+	pendingDataSize := bw.stream.GetDataSize()
+	n, err := bw.flush(ctx, deadline, data)
+
+	if n -= pendingDataSize; n < 0 {
+		n = 0
+	}
+
+	return n, err
+}
+
+func (bw *BufferedWriter) Flush(ctx context.Context, deadline time.Time) error {
+	bw.lock.Lock()
+	defer bw.lock.Unlock()
+
+	if bw.err != nil {
+		return bw.err
+	}
+
+	if err := bw.waitForBackgroundFlush(ctx, deadline); err != nil {
+		return err
+	}
+
+	if bw.stream.GetDataSize() == 0 {
+		return nil
+	}
+
+	_, err := bw.flush(ctx, deadline, nil)
+	return err
+}
+
+// Stop discards the buffered data, so Flush should be called beforehand for
+// the data to be written, and releases the buffers.
+func (bw *BufferedWriter) Stop() {
+	bw.lock.Lock()
+	bw.timer.Stop()
+	bw.timerIsSet = false
+
+	if bw.err == nil {
+		bw.err = ErrConnectionClosed
+	}
+
+	bw.isStopped = true
+	bw.stream.Release()
+
+	if !bw.isFlushing {
+		bw.flushingStream.Release()
+	}
+
+	bw.flushCondition.Broadcast()
+	bw.lock.Unlock()
+}
+
+func (bw *BufferedWriter) BufferedDataSize() int {
+	bw.lock.Lock()
+	bufferedDataSize := bw.stream.GetDataSize() + bw.flushingStream.GetDataSize()
+	bw.lock.Unlock()
+	return bufferedDataSize
+}
+
+// onTimer writes the buffered data without holding the lock, so that small
+// writes can still be buffered in the meantime.
+func (bw *BufferedWriter) onTimer() {
+	bw.lock.Lock()
+
+	if !bw.timerIsSet {
+		bw.lock.Unlock()
+		return
+	}
+
+	bw.timerIsSet = false
+
+	if bw.err != nil || bw.stream.GetDataSize() == 0 {
+		bw.lock.Unlock()
+		return
+	}
+
+	if bw.isFlushing {
+		bw.setTimer()
+		bw.lock.Unlock()
+		return
+	}
+
+	bw.stream, bw.flushingStream = bw.flushingStream, bw.stream
+	bw.isFlushing = true
+	data := bw.flushingStream.GetData()
+	bw.lock.Unlock()
+	n, err := bw.connection.WriteAll(context.Background(), time.Now().Add(bw.options.FlushTimeout), data)
+	bw.lock.Lock()
+	bw.isFlushing = false
+	bw.flushingStream.Skip(n)
+
+	if err != nil && isTransientError(err) && !bw.isStopped {
+		bw.flushingStream.Write(bw.stream.GetData())
+		bw.stream.Skip(bw.stream.GetDataSize())
+		bw.stream, bw.flushingStream = bw.flushingStream, bw.stream
+		bw.setTimer()
+	} else {
+		bw.flushingStream.Skip(bw.flushingStream.GetDataSize())
+
+		if err != nil && bw.err == nil {
+			bw.err = err
+		}
+	}
+
+	if bw.isStopped {
+		bw.flushingStream.Release()
+	}
+
+	bw.flushCondition.Broadcast()
+	bw.lock.Unlock()
+}
+
+func (bw *BufferedWriter) setTimer() {
+	if !bw.timerIsSet {
+		bw.timer.Reset(bw.options.MaxLatency)
+		bw.timerIsSet = true
+	}
+}
+
+func (bw *BufferedWriter) waitForBackgroundFlush(ctx context.Context, deadline time.Time) error {
+	if !bw.isFlushing {
+		return nil
+	}
+
+	ctx2 := ctx
+
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx2, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	for bw.isFlushing {
+		if _, err := bw.flushCondition.WaitFor(ctx2); err != nil {
+			if err == context.DeadlineExceeded && ctx.Err() == nil {
+				return ErrTimedOut
+			}
+
+			return err
+		}
+	}
+
+	if bw.err != nil {
+		return bw.err
+	}
+
+	return nil
+}
+
+func (bw *BufferedWriter) flush(ctx context.Context, deadline time.Time, extraData []byte) (int, error) {
+	if bw.timerIsSet {
+		bw.timer.Stop()
+		bw.timerIsSet = false
+	}
+
+	var n int
+	var err error
+	pendingDataSize := bw.stream.GetDataSize()
+
+	if len(extraData) == 0 {
+		n, err = bw.connection.WriteAll(ctx, deadline, bw.stream.GetData())
+	} else if pendingDataSize == 0 {
+		n, err = bw.connection.WriteAll(ctx, deadline, extraData)
+	} else {
+		n, err = bw.connection.WriteBuffers(ctx, deadline, net.Buffers{bw.stream.GetData(), extraData})
+		err = convertTimeoutError(err)
+	}
+
+	if err == nil || !isTransientError(err) {
+		bw.stream.Skip(pendingDataSize)
+
+		if err != nil {
+			bw.err = err
+		}
+
+		return n, err
+	}
+
+	if n < pendingDataSize {
+		bw.stream.Skip(n)
+		bw.setTimer()
+	} else {
+		bw.stream.Skip(pendingDataSize)
+	}
+
+	return n, err
+}
+
+func isTransientError(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return true
+	}
+
+	err2, ok := err.(net.Error)
+	return ok && err2.Timeout()
+}
+
+const (
+	defaultFlushThreshold = 16 * 1024
+	defaultMaxLatency     = time.Millisecond
+	defaultFlushTimeout   = 10 * time.Second
+)
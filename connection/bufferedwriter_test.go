@@ -0,0 +1,170 @@
+package connection
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBufferedWriter(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	bw := new(BufferedWriter).Init(c, BufferedWriterOptions{
+		FlushThreshold: 8,
+		MaxLatency:     time.Second / 20,
+	})
+
+	defer bw.Stop()
+	data := make(chan string, 10)
+
+	go func() {
+		var buffer [100]byte
+
+		for {
+			n, err := c2.Read(buffer[:])
+
+			if err != nil {
+				return
+			}
+
+			data <- string(buffer[:n])
+		}
+	}()
+
+	bw.Write(context.Background(), time.Time{}, []byte("abc"))
+	bw.Write(context.Background(), time.Time{}, []byte("de"))
+
+	if n, err := bw.Write(context.Background(), time.Time{}, []byte("fgh")); n != 3 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if s := <-data + <-data; s != "abcdefgh" {
+		t.Errorf("%#v", s)
+	}
+
+	startTime := time.Now()
+	bw.Write(context.Background(), time.Time{}, []byte("ij"))
+
+	if s := <-data; s != "ij" {
+		t.Errorf("%#v", s)
+	}
+
+	if elapsedTime := time.Since(startTime); elapsedTime < time.Second/20 {
+		t.Errorf("%#v", elapsedTime)
+	}
+
+	bw.Write(context.Background(), time.Time{}, []byte("k"))
+
+	if err := bw.Flush(context.Background(), time.Time{}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if s := <-data; s != "k" {
+		t.Errorf("%#v", s)
+	}
+}
+
+func TestBufferedWriterError(t *testing.T) {
+	c1, c2 := net.Pipe()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	bw := new(BufferedWriter).Init(c, BufferedWriterOptions{
+		FlushThreshold: 8,
+		MaxLatency:     time.Second / 20,
+	})
+
+	defer bw.Stop()
+	c2.Close()
+
+	if n, err := bw.Write(context.Background(), time.Time{}, []byte("abc")); n != 3 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	time.Sleep(time.Second / 10)
+
+	if n, err := bw.Write(context.Background(), time.Time{}, []byte("abc")); n != 0 || err != io.ErrClosedPipe {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if err := bw.Flush(context.Background(), time.Time{}); err != io.ErrClosedPipe {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestBufferedWriterCancellation(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	bw := new(BufferedWriter).Init(c, BufferedWriterOptions{
+		FlushThreshold: 8,
+		MaxLatency:     time.Hour,
+	})
+
+	defer bw.Stop()
+	bw.Write(context.Background(), time.Time{}, []byte("abc"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if n, err := bw.Write(ctx, time.Time{}, []byte("defgh")); n != 0 || err != context.Canceled {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if n := bw.BufferedDataSize(); n != 3 {
+		t.Errorf("%#v", n)
+	}
+
+	data := make(chan string, 1)
+
+	go func() {
+		buffer := make([]byte, 8)
+		n, _ := io.ReadFull(c2, buffer)
+		data <- string(buffer[:n])
+	}()
+
+	if n, err := bw.Write(context.Background(), time.Time{}, []byte("defgh")); n != 5 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if s := <-data; s != "abcdefgh" {
+		t.Errorf("%#v", s)
+	}
+}
+
+func TestBufferedWriterBackgroundFlush(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	bw := new(BufferedWriter).Init(c, BufferedWriterOptions{
+		FlushThreshold: 8,
+		MaxLatency:     time.Second / 50,
+	})
+
+	defer bw.Stop()
+	bw.Write(context.Background(), time.Time{}, []byte("abc"))
+	time.Sleep(time.Second / 10)
+	startTime := time.Now()
+
+	if n, err := bw.Write(context.Background(), time.Time{}, []byte("de")); n != 2 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if elapsedTime := time.Since(startTime); elapsedTime >= time.Second/20 {
+		t.Errorf("%#v", elapsedTime)
+	}
+
+	go io.Copy(io.Discard, c2)
+
+	if err := bw.Flush(context.Background(), time.Now().Add(time.Second)); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if n := bw.BufferedDataSize(); n != 0 {
+		t.Errorf("%#v", n)
+	}
+}
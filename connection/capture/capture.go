@@ -0,0 +1,275 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/let-z-go/toolkit/connection"
+	"github.com/let-z-go/toolkit/timerpool"
+)
+
+type Direction uint8
+
+const (
+	DirectionRead Direction = 1 + iota
+	DirectionWrite
+)
+
+type Record struct {
+	Direction Direction
+	Time      time.Time
+	Data      []byte
+}
+
+// A recording starts with the magic and the start time (uvarint nanoseconds
+// since the Unix epoch), followed by records, each of which consists of the
+// direction (1 byte), the time elapsed since the previous record (uvarint
+// nanoseconds), the data size (uvarint) and the data.
+type Recorder struct {
+	lock     sync.Mutex
+	writer   *bufio.Writer
+	lastTime time.Time
+	err      error
+}
+
+func (r *Recorder) Init(writer io.Writer) *Recorder {
+	r.writer = bufio.NewWriter(writer)
+	r.lastTime = time.Now()
+	r.writer.WriteString(magic)
+	r.writeUvarint(uint64(r.lastTime.UnixNano()))
+	return r
+}
+
+// Observer returns an observer recording the data read and written, which also
+// calls the hooks of the given observer.
+func (r *Recorder) Observer(next connection.Observer) connection.Observer {
+	observer := next
+
+	observer.OnReadData = func(connection *connection.Connection, data []byte) {
+		r.Record(DirectionRead, data)
+
+		if next.OnReadData != nil {
+			next.OnReadData(connection, data)
+		}
+	}
+
+	observer.OnWriteData = func(connection *connection.Connection, data []byte) {
+		r.Record(DirectionWrite, data)
+
+		if next.OnWriteData != nil {
+			next.OnWriteData(connection, data)
+		}
+	}
+
+	return observer
+}
+
+func (r *Recorder) Record(direction Direction, data []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.err != nil {
+		return
+	}
+
+	now := time.Now()
+	elapsedTime := now.Sub(r.lastTime)
+
+	if elapsedTime < 0 {
+		elapsedTime = 0
+	}
+
+	r.lastTime = now
+	r.writer.WriteByte(byte(direction))
+	r.writeUvarint(uint64(elapsedTime))
+	r.writeUvarint(uint64(len(data)))
+
+	if _, err := r.writer.Write(data); err != nil {
+		r.err = err
+	}
+}
+
+func (r *Recorder) Flush() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	r.err = r.writer.Flush()
+	return r.err
+}
+
+func (r *Recorder) writeUvarint(x uint64) {
+	var buffer [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buffer[:], x)
+	r.writer.Write(buffer[:n])
+}
+
+type Reader struct {
+	reader   *bufio.Reader
+	lastTime time.Time
+	err      error
+}
+
+func (r *Reader) Init(reader io.Reader) *Reader {
+	r.reader = bufio.NewReader(reader)
+	var buffer [len(magic)]byte
+
+	if _, err := io.ReadFull(r.reader, buffer[:]); err != nil || string(buffer[:]) != magic {
+		r.err = ErrInvalidRecording
+		return r
+	}
+
+	startTime, err := binary.ReadUvarint(r.reader)
+
+	if err != nil {
+		r.err = ErrInvalidRecording
+		return r
+	}
+
+	r.lastTime = time.Unix(0, int64(startTime))
+	return r
+}
+
+func (r *Reader) ReadRecord() (Record, error) {
+	if r.err != nil {
+		return Record{}, r.err
+	}
+
+	direction, err := r.reader.ReadByte()
+
+	if err != nil {
+		r.err = err
+		return Record{}, err
+	}
+
+	if Direction(direction) != DirectionRead && Direction(direction) != DirectionWrite {
+		r.err = ErrInvalidRecording
+		return Record{}, r.err
+	}
+
+	elapsedTime, err := binary.ReadUvarint(r.reader)
+
+	if err != nil {
+		r.err = ErrInvalidRecording
+		return Record{}, r.err
+	}
+
+	dataSize, err := binary.ReadUvarint(r.reader)
+
+	if err != nil || dataSize > maxRecordDataSize {
+		r.err = ErrInvalidRecording
+		return Record{}, r.err
+	}
+
+	data := make([]byte, dataSize)
+
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		r.err = ErrInvalidRecording
+		return Record{}, r.err
+	}
+
+	r.lastTime = r.lastTime.Add(time.Duration(elapsedTime))
+
+	return Record{
+		Direction: Direction(direction),
+		Time:      r.lastTime,
+		Data:      data,
+	}, nil
+}
+
+type ReplayerOptions struct {
+	KeepsTiming  bool
+	VerifiesData bool
+}
+
+// Replayer plays the peer of the recorded connection: the data the recorded
+// connection read is written to the given connection, and the data it wrote
+// is read from the given connection.
+type Replayer struct {
+	reader  *Reader
+	options ReplayerOptions
+}
+
+func (r *Replayer) Init(reader *Reader, options ReplayerOptions) *Replayer {
+	r.reader = reader
+	r.options = options
+	return r
+}
+
+func (r *Replayer) Replay(ctx context.Context, peer *connection.Connection) error {
+	var lastRecordTime, lastReplayTime time.Time
+
+	for {
+		record, err := r.reader.ReadRecord()
+
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if r.options.KeepsTiming && !lastRecordTime.IsZero() {
+			if delay := record.Time.Sub(lastRecordTime) - time.Since(lastReplayTime); delay >= 1 {
+				timer := timerpool.GetTimer(delay)
+
+				select {
+				case <-timer.C:
+					timerpool.PutTimer(timer)
+				case <-ctx.Done():
+					timerpool.StopAndPutTimer(timer)
+					return ctx.Err()
+				}
+			}
+		}
+
+		lastRecordTime = record.Time
+		lastReplayTime = time.Now()
+
+		switch record.Direction {
+		case DirectionRead:
+			if _, err := peer.WriteAll(ctx, time.Time{}, record.Data); err != nil {
+				return err
+			}
+		case DirectionWrite:
+			data := make([]byte, len(record.Data))
+
+			if _, err := peer.ReadFull(ctx, time.Time{}, data); err != nil {
+				return err
+			}
+
+			if r.options.VerifiesData && !bytes.Equal(data, record.Data) {
+				return &DataMismatchError{
+					Expected: record.Data,
+					Actual:   data,
+				}
+			}
+		}
+	}
+}
+
+type DataMismatchError struct {
+	Expected []byte
+	Actual   []byte
+}
+
+func (dme *DataMismatchError) Error() string {
+	return fmt.Sprintf("toolkit/capture: data mismatch: expected=%q, actual=%q", dme.Expected, dme.Actual)
+}
+
+var ErrInvalidRecording = errors.New("toolkit/capture: invalid recording")
+
+const magic = "TKCAP1\n"
+
+const maxRecordDataSize = 1 << 30
@@ -0,0 +1,124 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/let-z-go/toolkit/connection"
+	"github.com/let-z-go/toolkit/connection/connectiontest"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := new(Recorder).Init(&recording)
+	client, server := connectiontest.NewPair(connectiontest.Options{}, connectiontest.Options{})
+	var numberOfCloses, readDataSize int
+	client.SetObserver(recorder.Observer(connection.Observer{
+		OnClose: func(*connection.Connection, error) {
+			numberOfCloses++
+		},
+		OnReadData: func(_ *connection.Connection, data []byte) {
+			readDataSize += len(data)
+		},
+	}))
+
+	go func() {
+		var buffer [4]byte
+		server.ReadFull(context.Background(), time.Time{}, buffer[:])
+		time.Sleep(time.Second / 10)
+		server.Write(context.Background(), time.Time{}, []byte("pong"))
+		server.Close()
+	}()
+
+	runClient(t, client)
+	client.Close()
+
+	if numberOfCloses != 1 || readDataSize != 4 {
+		t.Errorf("%#v %#v", numberOfCloses, readDataSize)
+	}
+
+	if err := recorder.Flush(); err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	reader := new(Reader).Init(bytes.NewReader(recording.Bytes()))
+	var records []Record
+
+	for {
+		record, err := reader.ReadRecord()
+
+		if err != nil {
+			break
+		}
+
+		records = append(records, record)
+	}
+
+	if len(records) != 2 || records[0].Direction != DirectionWrite || string(records[0].Data) != "ping" || records[1].Direction != DirectionRead || string(records[1].Data) != "pong" {
+		t.Fatalf("%#v", records)
+	}
+
+	if d := records[1].Time.Sub(records[0].Time); d < time.Second/10 {
+		t.Errorf("%#v", d)
+	}
+
+	for _, keepsTiming := range []bool{false, true} {
+		replayer := new(Replayer).Init(new(Reader).Init(bytes.NewReader(recording.Bytes())), ReplayerOptions{
+			KeepsTiming:  keepsTiming,
+			VerifiesData: true,
+		})
+
+		client, server = connectiontest.NewPair(connectiontest.Options{}, connectiontest.Options{})
+		errs := make(chan error, 1)
+
+		go func() {
+			errs <- replayer.Replay(context.Background(), server)
+		}()
+
+		startTime := time.Now()
+		runClient(t, client)
+		elapsedTime := time.Since(startTime)
+
+		if err := <-errs; err != nil {
+			t.Errorf("%#v", err)
+		}
+
+		if (elapsedTime >= time.Second/10) != keepsTiming {
+			t.Errorf("%#v %#v", keepsTiming, elapsedTime)
+		}
+
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	var recording bytes.Buffer
+	recorder := new(Recorder).Init(&recording)
+	recorder.Record(DirectionWrite, []byte("ping"))
+	recorder.Flush()
+	replayer := new(Replayer).Init(new(Reader).Init(&recording), ReplayerOptions{VerifiesData: true})
+	client, server := connectiontest.NewPair(connectiontest.Options{}, connectiontest.Options{})
+	defer client.Close()
+	defer server.Close()
+	client.Write(context.Background(), time.Time{}, []byte("pang"))
+
+	if err, ok := replayer.Replay(context.Background(), server).(*DataMismatchError); !ok || string(err.Actual) != "pang" {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := new(Reader).Init(bytes.NewReader([]byte("garbage"))).ReadRecord(); err != ErrInvalidRecording {
+		t.Errorf("%#v", err)
+	}
+}
+
+func runClient(t *testing.T, client *connection.Connection) {
+	client.Write(context.Background(), time.Time{}, []byte("ping"))
+	var buffer [4]byte
+
+	if _, err := client.ReadFull(context.Background(), time.Time{}, buffer[:]); err != nil || string(buffer[:]) != "pong" {
+		t.Errorf("%#v %#v", err, buffer)
+	}
+}
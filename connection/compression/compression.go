@@ -0,0 +1,385 @@
+package compression
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/let-z-go/toolkit/connection"
+	"github.com/let-z-go/toolkit/timerpool"
+	"github.com/let-z-go/toolkit/utils"
+)
+
+type Algorithm int
+
+const (
+	AlgorithmFlate Algorithm = 1 + iota
+	AlgorithmGzip
+	AlgorithmZlib
+)
+
+// NoCompression selects flate.NoCompression, as a zero Level means
+// flate.DefaultCompression.
+const NoCompression = flate.HuffmanOnly - 1
+
+type Options struct {
+	Algorithm Algorithm
+	Level     int
+}
+
+func (o *Options) normalize() {
+	if o.Algorithm == 0 {
+		o.Algorithm = AlgorithmFlate
+	}
+
+	if o.Level == 0 {
+		o.Level = flate.DefaultCompression
+	} else if o.Level == NoCompression {
+		o.Level = flate.NoCompression
+	}
+}
+
+// Stream compresses the data written to and decompresses the data read from
+// a connection. Timeouts and cancellations leave the stream usable, whereas
+// any other error is sticky: the direction concerned cannot be used any more.
+//
+// The codecs never see timeouts or cancellations, as their own errors are
+// sticky: compressed data that could not be written is held back until the
+// next Write, Flush or Close, and decompression runs in a goroutine reading
+// the connection without a deadline, which Read waits for. That goroutine
+// ends once it has decompressed some data or the connection fails.
+type Stream struct {
+	connection         *connection.Connection
+	options            Options
+	compressor         compressor
+	decompressor       io.Reader
+	reader             connectionReader
+	writer             connectionWriter
+	readBuffer         []byte
+	readData           []byte
+	readResults        chan readResult
+	isDecompressing    bool
+	compressorIsClosed bool
+	readErr            error
+	writeErr           error
+}
+
+func (s *Stream) Init(connection *connection.Connection, options Options) *Stream {
+	options.normalize()
+
+	utils.Assert(options.Algorithm >= AlgorithmFlate && options.Algorithm <= AlgorithmZlib, func() string {
+		return fmt.Sprintf("toolkit/compression: invalid argument: algorithm=%#v", options.Algorithm)
+	})
+
+	utils.Assert(options.Level >= flate.HuffmanOnly && options.Level <= flate.BestCompression, func() string {
+		return fmt.Sprintf("toolkit/compression: invalid argument: level=%#v", options.Level)
+	})
+
+	s.connection = connection
+	s.options = options
+	s.reader.connection = connection
+	s.writer.connection = connection
+	s.readResults = make(chan readResult, 1)
+
+	switch options.Algorithm {
+	case AlgorithmFlate:
+		s.compressor, _ = flate.NewWriter(&s.writer, options.Level)
+	case AlgorithmGzip:
+		s.compressor, _ = gzip.NewWriterLevel(&s.writer, options.Level)
+	case AlgorithmZlib:
+		s.compressor, _ = zlib.NewWriterLevel(&s.writer, options.Level)
+	}
+
+	return s
+}
+
+// Write may keep the compressed data buffered until Flush is called. On a
+// timeout or cancellation, the data is still accepted and the compressed
+// data not yet written is retried by the next Write, Flush or Close.
+func (s *Stream) Write(ctx context.Context, deadline time.Time, data []byte) (int, error) {
+	if err := s.beginWrite(ctx, deadline); err != nil {
+		return 0, err
+	}
+
+	n, err := s.compressor.Write(data)
+	return n, s.endWrite(err)
+}
+
+// Flush writes all the pending data with a sync flush, so that the peer can
+// decompress everything written so far.
+func (s *Stream) Flush(ctx context.Context, deadline time.Time) error {
+	if err := s.beginWrite(ctx, deadline); err != nil {
+		return err
+	}
+
+	return s.endWrite(s.compressor.Flush())
+}
+
+// Close finishes the compressed stream, after which the peer reads io.EOF.
+// The connection is left open. On a timeout or cancellation, Close may be
+// called again to write the rest of the stream.
+func (s *Stream) Close(ctx context.Context, deadline time.Time) error {
+	if s.writeErr != nil {
+		return s.writeErr
+	}
+
+	s.writer.ctx, s.writer.deadline = ctx, deadline
+
+	if err := s.writer.FlushPendingData(); err != nil {
+		return s.setWriteError(err)
+	}
+
+	if !s.compressorIsClosed {
+		s.compressorIsClosed = true
+
+		if err := s.endWrite(s.compressor.Close()); err != nil {
+			return err
+		}
+	}
+
+	s.writeErr = ErrStreamClosed
+	return nil
+}
+
+func (s *Stream) Read(ctx context.Context, deadline time.Time, buffer []byte) (int, error) {
+	if len(s.readData) >= 1 {
+		return s.readDecompressedData(buffer), nil
+	}
+
+	if s.readErr != nil {
+		return 0, s.readErr
+	}
+
+	if len(buffer) == 0 {
+		return 0, nil
+	}
+
+	if !s.isDecompressing {
+		s.isDecompressing = true
+		go s.decompress()
+	}
+
+	result, err := s.waitForReadResult(ctx, deadline)
+
+	if err != nil {
+		return 0, err
+	}
+
+	s.isDecompressing = false
+	s.readData = s.readBuffer[:result.N]
+	n := s.readDecompressedData(buffer)
+
+	if result.Err != nil {
+		s.readErr = result.Err
+
+		if n == 0 {
+			return 0, result.Err
+		}
+	}
+
+	return n, nil
+}
+
+func (s *Stream) beginWrite(ctx context.Context, deadline time.Time) error {
+	if s.writeErr != nil {
+		return s.writeErr
+	}
+
+	if s.compressorIsClosed {
+		return ErrStreamClosed
+	}
+
+	s.writer.ctx, s.writer.deadline = ctx, deadline
+
+	if err := s.writer.FlushPendingData(); err != nil {
+		return s.setWriteError(err)
+	}
+
+	return nil
+}
+
+func (s *Stream) endWrite(err error) error {
+	if err == nil {
+		err = s.writer.err
+	}
+
+	if err != nil {
+		return s.setWriteError(err)
+	}
+
+	return nil
+}
+
+func (s *Stream) setWriteError(err error) error {
+	if !isTransientError(err) {
+		s.writeErr = err
+	}
+
+	return err
+}
+
+func (s *Stream) decompress() {
+	if s.decompressor == nil {
+		if err := s.initDecompressor(); err != nil {
+			s.readResults <- readResult{Err: err}
+			return
+		}
+
+		s.readBuffer = make([]byte, readBufferSize)
+	}
+
+	n, err := s.decompressor.Read(s.readBuffer)
+	s.readResults <- readResult{N: n, Err: err}
+}
+
+func (s *Stream) initDecompressor() error {
+	switch s.options.Algorithm {
+	case AlgorithmFlate:
+		s.decompressor = flate.NewReader(&s.reader)
+	case AlgorithmGzip:
+		gzipReader, err := gzip.NewReader(&s.reader)
+
+		if err != nil {
+			return err
+		}
+
+		gzipReader.Multistream(false)
+		s.decompressor = gzipReader
+	case AlgorithmZlib:
+		zlibReader, err := zlib.NewReader(&s.reader)
+
+		if err != nil {
+			return err
+		}
+
+		s.decompressor = zlibReader
+	}
+
+	return nil
+}
+
+func (s *Stream) waitForReadResult(ctx context.Context, deadline time.Time) (readResult, error) {
+	select {
+	case result := <-s.readResults:
+		return result, nil
+	default:
+	}
+
+	if deadline.IsZero() {
+		select {
+		case result := <-s.readResults:
+			return result, nil
+		case <-ctx.Done():
+			return readResult{}, ctx.Err()
+		}
+	}
+
+	timeout := time.Until(deadline)
+
+	if timeout <= 0 {
+		return readResult{}, connection.ErrTimedOut
+	}
+
+	timer := timerpool.GetTimer(timeout)
+
+	select {
+	case result := <-s.readResults:
+		timerpool.StopAndPutTimer(timer)
+		return result, nil
+	case <-ctx.Done():
+		timerpool.StopAndPutTimer(timer)
+		return readResult{}, ctx.Err()
+	case <-timer.C:
+		timerpool.PutTimer(timer)
+		return readResult{}, connection.ErrTimedOut
+	}
+}
+
+func (s *Stream) readDecompressedData(buffer []byte) int {
+	n := copy(buffer, s.readData)
+	s.readData = s.readData[n:]
+	return n
+}
+
+var ErrStreamClosed = errors.New("toolkit/compression: stream closed")
+
+const readBufferSize = 16 * 1024
+
+type compressor interface {
+	io.WriteCloser
+
+	Flush() error
+}
+
+type readResult struct {
+	N   int
+	Err error
+}
+
+type connectionReader struct {
+	connection *connection.Connection
+}
+
+func (cr *connectionReader) Read(buffer []byte) (int, error) {
+	return cr.connection.ReadAtLeast(context.Background(), time.Time{}, buffer, 1)
+}
+
+// connectionWriter holds back the data it fails to write on a timeout or
+// cancellation and reports success, keeping the error in err, so that the
+// compressor never sees it.
+type connectionWriter struct {
+	connection  *connection.Connection
+	ctx         context.Context
+	deadline    time.Time
+	pendingData []byte
+	err         error
+}
+
+func (cw *connectionWriter) FlushPendingData() error {
+	cw.err = nil
+
+	if len(cw.pendingData) == 0 {
+		return nil
+	}
+
+	n, err := cw.connection.WriteAll(cw.ctx, cw.deadline, cw.pendingData)
+	cw.pendingData = cw.pendingData[n:]
+
+	if len(cw.pendingData) == 0 {
+		cw.pendingData = nil
+	}
+
+	return err
+}
+
+func (cw *connectionWriter) Write(data []byte) (int, error) {
+	if cw.err != nil {
+		cw.pendingData = append(cw.pendingData, data...)
+		return len(data), nil
+	}
+
+	n, err := cw.connection.WriteAll(cw.ctx, cw.deadline, data)
+
+	if err == nil || !isTransientError(err) {
+		return n, err
+	}
+
+	cw.pendingData = append(cw.pendingData, data[n:]...)
+	cw.err = err
+	return len(data), nil
+}
+
+func isTransientError(err error) bool {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return true
+	}
+
+	err2, ok := err.(net.Error)
+	return ok && err2.Timeout()
+}
@@ -0,0 +1,144 @@
+package compression
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/let-z-go/toolkit/connection"
+	"github.com/let-z-go/toolkit/connection/connectiontest"
+)
+
+func TestStream(t *testing.T) {
+	for _, algorithm := range []Algorithm{AlgorithmFlate, AlgorithmGzip, AlgorithmZlib} {
+		c1, c2 := connectiontest.NewPair(connectiontest.Options{}, connectiontest.Options{})
+		s1 := new(Stream).Init(c1, Options{Algorithm: algorithm})
+		s2 := new(Stream).Init(c2, Options{Algorithm: algorithm})
+		message := bytes.Repeat([]byte("hello world "), 1000)
+
+		for i := 0; i < 3; i++ {
+			if _, err := s1.Write(context.Background(), time.Time{}, message); err != nil {
+				t.Fatalf("%#v %#v", algorithm, err)
+			}
+
+			if err := s1.Flush(context.Background(), time.Time{}); err != nil {
+				t.Fatalf("%#v %#v", algorithm, err)
+			}
+
+			if data, err := readFull(s2, len(message)); err != nil || !bytes.Equal(data, message) {
+				t.Fatalf("%#v %#v", algorithm, err)
+			}
+		}
+
+		if n := c1.Stats().NumberOfBytesWritten; n >= int64(len(message)) {
+			t.Errorf("%#v %#v", algorithm, n)
+		}
+
+		if err := s1.Close(context.Background(), time.Time{}); err != nil {
+			t.Errorf("%#v %#v", algorithm, err)
+		}
+
+		if _, err := s1.Write(context.Background(), time.Time{}, message); err != ErrStreamClosed {
+			t.Errorf("%#v %#v", algorithm, err)
+		}
+
+		var buffer [1]byte
+
+		if _, err := s2.Read(context.Background(), time.Now().Add(time.Second), buffer[:]); err != io.EOF {
+			t.Errorf("%#v %#v", algorithm, err)
+		}
+
+		c1.Close()
+		c2.Close()
+	}
+}
+
+func TestStreamReadTimeout(t *testing.T) {
+	c1, c2 := connectiontest.NewPair(connectiontest.Options{}, connectiontest.Options{})
+	defer c1.Close()
+	defer c2.Close()
+	s1 := new(Stream).Init(c1, Options{Algorithm: AlgorithmGzip})
+	s2 := new(Stream).Init(c2, Options{Algorithm: AlgorithmGzip})
+	var buffer [5]byte
+
+	if _, err := s2.Read(context.Background(), time.Now().Add(time.Second/20), buffer[:]); err != connection.ErrTimedOut {
+		t.Errorf("%#v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s2.Read(ctx, time.Time{}, buffer[:]); err != context.Canceled {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := s1.Write(context.Background(), time.Time{}, []byte("hello")); err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if err := s1.Flush(context.Background(), time.Time{}); err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if data, err := readFull(s2, 5); err != nil || string(data) != "hello" {
+		t.Errorf("%#v %#v", string(data), err)
+	}
+}
+
+func TestStreamWriteTimeout(t *testing.T) {
+	c1, c2 := connectiontest.NewPair(connectiontest.Options{BufferSize: 1024}, connectiontest.Options{})
+	defer c1.Close()
+	defer c2.Close()
+	s1 := new(Stream).Init(c1, Options{Level: NoCompression})
+	s2 := new(Stream).Init(c2, Options{})
+	message := bytes.Repeat([]byte("hello world "), 1000)
+
+	if _, err := s1.Write(context.Background(), time.Time{}, message); err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if err := s1.Flush(context.Background(), time.Now().Add(time.Second/20)); err != connection.ErrTimedOut {
+		t.Fatalf("%#v", err)
+	}
+
+	if n := c1.Stats().NumberOfBytesWritten; n >= int64(len(message)) {
+		t.Errorf("%#v", n)
+	}
+
+	errs := make(chan error, 1)
+
+	go func() {
+		data, err := readFull(s2, len(message))
+
+		if err == nil && !bytes.Equal(data, message) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		errs <- err
+	}()
+
+	if err := s1.Flush(context.Background(), time.Time{}); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := <-errs; err != nil {
+		t.Errorf("%#v", err)
+	}
+}
+
+func readFull(s *Stream, dataSize int) ([]byte, error) {
+	data := make([]byte, dataSize)
+
+	for i := 0; i < dataSize; {
+		n, err := s.Read(context.Background(), time.Now().Add(time.Second), data[i:])
+		i += n
+
+		if err != nil {
+			return data[:i], err
+		}
+	}
+
+	return data, nil
+}
@@ -2,21 +2,33 @@ package connection
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/let-z-go/toolkit/utils"
 )
 
 type Connection struct {
-	underlying       net.Conn
-	preReading       chan struct{}
-	preWriting       chan struct{}
-	lockOfPreReading sync.Mutex
-	lockOfPreWriting sync.Mutex
-	latestReadCtx    context.Context
-	latestWriteCtx   context.Context
+	lastReadTime      int64
+	lastWriteTime     int64
+	closeFlags        int32
+	counters          counters
+	underlying        net.Conn
+	readWatcher       watcher
+	writeWatcher      watcher
+	keepalive         *keepalive
+	abortReason       atomic.Value
+	observer          Observer
+	statsGroup        *StatsGroup
+	readRateLimiters  []*RateLimiter
+	writeRateLimiters []*RateLimiter
+	onClosed          func()
+	pendingData       []byte
+	derivesDeadlines  bool
 }
 
 func (c *Connection) Init(underlying net.Conn) *Connection {
@@ -25,62 +37,29 @@ func (c *Connection) Init(underlying net.Conn) *Connection {
 	})
 
 	c.underlying = underlying
-	c.preReading = make(chan struct{}, 1)
-	c.preWriting = make(chan struct{}, 1)
-
-	go func() {
-		readCtx := context.Background()
-		writeCtx := context.Background()
-
-		for {
-			select {
-			case _, ok := <-c.preReading:
-				if !ok {
-					return
-				}
-
-				c.lockOfPreReading.Lock()
-				readCtx = c.latestReadCtx
-				c.lockOfPreReading.Unlock()
-			case _, ok := <-c.preWriting:
-				if !ok {
-					return
-				}
-
-				c.lockOfPreWriting.Lock()
-				writeCtx = c.latestWriteCtx
-				c.lockOfPreWriting.Unlock()
-			case <-readCtx.Done():
-				c.lockOfPreReading.Lock()
-
-				if readCtx == c.latestReadCtx {
-					underlying.SetReadDeadline(time.Now())
-				}
-
-				c.lockOfPreReading.Unlock()
-				readCtx = context.Background()
-			case <-writeCtx.Done():
-				c.lockOfPreWriting.Lock()
-
-				if writeCtx == c.latestWriteCtx {
-					underlying.SetWriteDeadline(time.Now())
-				}
-
-				c.lockOfPreWriting.Unlock()
-				writeCtx = context.Background()
-			}
-		}
-	}()
-
+	c.readWatcher.Init(underlying.SetReadDeadline)
+	c.writeWatcher.Init(underlying.SetWriteDeadline)
 	return c
 }
 
 func (c *Connection) Close() error {
-	close(c.preReading)
-	close(c.preWriting)
-	underlying := c.underlying
-	c.underlying = nil
-	return underlying.Close()
+	if !c.setCloseFlags(closeFlagClosed | closeFlagReadClosed | closeFlagWriteClosed) {
+		return ErrConnectionClosed
+	}
+
+	if c.keepalive != nil {
+		c.keepalive.close(nil)
+	}
+
+	return c.doClose()
+}
+
+// DeriveDeadlinesFromContext must be called before the connection is used.
+// Once enabled, the deadline of every operation is the earlier of the explicit
+// deadline and the deadline of the context, and timeouts are reported as
+// context.DeadlineExceeded or ErrTimedOut depending on which one expired.
+func (c *Connection) DeriveDeadlinesFromContext() {
+	c.derivesDeadlines = true
 }
 
 func (c *Connection) Read(ctx context.Context, deadline time.Time, buffer []byte) (int, error) {
@@ -89,26 +68,58 @@ func (c *Connection) Read(ctx context.Context, deadline time.Time, buffer []byte
 }
 
 func (c *Connection) PreRead(ctx context.Context, deadline time.Time) {
-	c.lockOfPreReading.Lock()
-	c.latestReadCtx = ctx
-	c.underlying.SetReadDeadline(deadline)
-	c.lockOfPreReading.Unlock()
+	c.readWatcher.Watch(ctx, deadline, c.derivesDeadlines)
+}
 
-	select {
-	case c.preReading <- struct{}{}:
-	default:
+func (c *Connection) CloseRead() error {
+	underlying, ok := c.underlying.(interface{ CloseRead() error })
+
+	if !ok {
+		return ErrHalfCloseNotSupported
+	}
+
+	if !c.setCloseFlags(closeFlagReadClosed) {
+		return ErrConnectionClosed
 	}
+
+	return underlying.CloseRead()
+}
+
+func (c *Connection) CloseWrite() error {
+	underlying, ok := c.underlying.(interface{ CloseWrite() error })
+
+	if !ok {
+		return ErrHalfCloseNotSupported
+	}
+
+	if !c.setCloseFlags(closeFlagWriteClosed) {
+		return ErrConnectionClosed
+	}
+
+	return underlying.CloseWrite()
 }
 
 func (c *Connection) DoRead(ctx context.Context, buffer []byte) (int, error) {
-	n, err := c.underlying.Read(buffer)
+	if c.hasCloseFlags(closeFlagReadClosed) {
+		return 0, c.getCloseError()
+	}
 
-	if err != nil {
-		if err2 := ctx.Err(); err2 != nil {
-			err = err2
-		}
+	if len(c.pendingData) >= 1 && len(buffer) >= 1 {
+		return c.readPendingData(buffer), nil
 	}
 
+	if len(c.readRateLimiters) == 0 || len(buffer) == 0 {
+		return c.doRead(ctx, buffer)
+	}
+
+	if err := waitForRateLimiterDebts(ctx, c.readWatcher.Deadline(), c.readRateLimiters); err != nil {
+		err = c.convertError(ctx, err, &c.readWatcher)
+		c.onRead(0, err, 0)
+		return 0, err
+	}
+
+	n, err := c.doRead(ctx, buffer)
+	chargeRateLimiters(c.readRateLimiters, n)
 	return n, err
 }
 
@@ -118,29 +129,311 @@ func (c *Connection) Write(ctx context.Context, deadline time.Time, data []byte)
 }
 
 func (c *Connection) PreWrite(ctx context.Context, deadline time.Time) {
-	c.lockOfPreWriting.Lock()
-	c.latestWriteCtx = ctx
-	c.underlying.SetWriteDeadline(deadline)
-	c.lockOfPreWriting.Unlock()
+	c.writeWatcher.Watch(ctx, deadline, c.derivesDeadlines)
+}
 
-	select {
-	case c.preWriting <- struct{}{}:
-	default:
+func (c *Connection) DoWrite(ctx context.Context, data []byte) (int, error) {
+	if c.hasCloseFlags(closeFlagWriteClosed) {
+		return 0, c.getCloseError()
+	}
+
+	if len(c.writeRateLimiters) == 0 || len(data) == 0 {
+		return c.doWrite(ctx, data)
 	}
+
+	deadline := c.writeWatcher.Deadline()
+	dataSize := 0
+
+	for dataSize < len(data) {
+		chunkSize, err := waitForRateLimiters(ctx, deadline, c.writeRateLimiters, len(data)-dataSize)
+
+		if err != nil {
+			err = c.convertError(ctx, err, &c.writeWatcher)
+			c.onWrite(0, err, 0)
+			return dataSize, err
+		}
+
+		n, err := c.doWrite(ctx, data[dataSize:dataSize+chunkSize])
+		dataSize += n
+		releaseRateLimiters(c.writeRateLimiters, chunkSize-n)
+
+		if err != nil {
+			return dataSize, err
+		}
+	}
+
+	return dataSize, nil
 }
 
-func (c *Connection) DoWrite(ctx context.Context, data []byte) (int, error) {
-	n, err := c.underlying.Write(data)
+func (c *Connection) WriteBuffers(ctx context.Context, deadline time.Time, buffers net.Buffers) (int, error) {
+	c.PreWrite(ctx, deadline)
+	return c.DoWriteBuffers(ctx, buffers)
+}
+
+func (c *Connection) DoWriteBuffers(ctx context.Context, buffers net.Buffers) (int, error) {
+	if c.hasCloseFlags(closeFlagWriteClosed) {
+		return 0, c.getCloseError()
+	}
+
+	if len(c.writeRateLimiters) == 0 {
+		return c.doWriteBuffers(ctx, buffers)
+	}
+
+	dataSize := 0
+
+	for _, data := range buffers {
+		n, err := c.DoWrite(ctx, data)
+		dataSize += n
+
+		if err != nil {
+			return dataSize, err
+		}
+	}
+
+	return dataSize, nil
+}
+
+func (c *Connection) IsClosed() bool {
+	return c.hasCloseFlags(closeFlagClosed)
+}
+
+func (c *Connection) convertError(ctx context.Context, err error, watcher *watcher) error {
+	return convertError(ctx, err, c.getAbortReason(), c.IsClosed(), c.derivesDeadlines, watcher)
+}
+
+func (c *Connection) doClose() error {
+	c.readWatcher.Close()
+	c.writeWatcher.Close()
+	err := c.underlying.Close()
+
+	if c.onClosed != nil {
+		c.onClosed()
+	}
+
+	c.onClose(err)
+	return err
+}
+
+func (c *Connection) getCloseError() error {
+	if err := c.getAbortReason(); err != nil {
+		return err
+	}
+
+	return ErrConnectionClosed
+}
+
+func (c *Connection) setCloseFlags(closeFlags int32) bool {
+	for {
+		oldCloseFlags := atomic.LoadInt32(&c.closeFlags)
+
+		if oldCloseFlags&closeFlags == closeFlags {
+			return false
+		}
+
+		if atomic.CompareAndSwapInt32(&c.closeFlags, oldCloseFlags, oldCloseFlags|closeFlags) {
+			return true
+		}
+	}
+}
+
+func (c *Connection) hasCloseFlags(closeFlags int32) bool {
+	return atomic.LoadInt32(&c.closeFlags)&closeFlags != 0
+}
+
+// readPendingData does not count the data again, as it has already been read
+// from the underlying connection.
+func (c *Connection) readPendingData(buffer []byte) int {
+	n := copy(buffer, c.pendingData)
+	c.pendingData = c.pendingData[n:]
+
+	if len(c.pendingData) == 0 {
+		c.pendingData = nil
+	}
+
+	return n
+}
+
+func (c *Connection) doRead(ctx context.Context, buffer []byte) (int, error) {
+	startTime := time.Now()
+	n, err := c.underlying.Read(buffer)
+	endTime := time.Now()
+
+	if n >= 1 {
+		atomic.StoreInt64(&c.lastReadTime, endTime.UnixNano())
+		c.onReadData(buffer[:n])
+	}
 
 	if err != nil {
-		if err2 := ctx.Err(); err2 != nil {
-			err = err2
+		if err == io.EOF && c.keepalive != nil {
+			c.keepalive.onPeerClosed()
 		}
+
+		err = c.convertError(ctx, err, &c.readWatcher)
 	}
 
+	c.onRead(n, err, endTime.Sub(startTime))
 	return n, err
 }
 
-func (c *Connection) IsClosed() bool {
-	return c.underlying == nil
+func (c *Connection) doWrite(ctx context.Context, data []byte) (int, error) {
+	startTime := time.Now()
+	n, err := c.underlying.Write(data)
+	c.onWriteData(data[:n])
+	return c.afterWrite(ctx, startTime, n, err)
+}
+
+func (c *Connection) doWriteBuffers(ctx context.Context, buffers net.Buffers) (int, error) {
+	buffers2 := append(net.Buffers(nil), buffers...)
+	startTime := time.Now()
+	n, err := buffers2.WriteTo(c.underlying)
+
+	for i, dataSize := 0, int(n); dataSize >= 1; i++ {
+		data := buffers[i]
+
+		if len(data) > dataSize {
+			data = data[:dataSize]
+		}
+
+		c.onWriteData(data)
+		dataSize -= len(data)
+	}
+
+	return c.afterWrite(ctx, startTime, int(n), err)
+}
+
+func (c *Connection) afterWrite(ctx context.Context, startTime time.Time, n int, err error) (int, error) {
+	endTime := time.Now()
+
+	if n >= 1 {
+		atomic.StoreInt64(&c.lastWriteTime, endTime.UnixNano())
+	}
+
+	if err != nil {
+		err = c.convertError(ctx, err, &c.writeWatcher)
+	}
+
+	c.onWrite(n, err, endTime.Sub(startTime))
+	return n, err
+}
+
+var (
+	ErrConnectionClosed      = errors.New("toolkit/connection: connection closed")
+	ErrHalfCloseNotSupported = errors.New("toolkit/connection: half-close not supported")
+)
+
+const (
+	closeFlagClosed int32 = 1 << iota
+	closeFlagReadClosed
+	closeFlagWriteClosed
+)
+
+// convertError maps an I/O error to the error reported to the caller: ctx
+// errors first, then the abort reason and ErrConnectionClosed, and finally
+// timeouts if deadlines are derived from contexts.
+func convertError(ctx context.Context, err error, abortReason error, isClosed bool, derivesDeadlines bool, watcher *watcher) error {
+	if err2 := ctx.Err(); err2 != nil {
+		return err2
+	}
+
+	if abortReason != nil {
+		return abortReason
+	}
+
+	if isClosed {
+		return ErrConnectionClosed
+	}
+
+	if derivesDeadlines {
+		if err2, ok := err.(net.Error); ok && err2.Timeout() {
+			if watcher.DeadlineIsFromContext() {
+				return context.DeadlineExceeded
+			}
+
+			return ErrTimedOut
+		}
+	}
+
+	return err
+}
+
+type watcher struct {
+	lock                  sync.Mutex
+	setDeadline           func(time.Time) error
+	latestCtx             context.Context
+	deadline              time.Time
+	deadlineIsFromContext bool
+	stop                  func() bool
+	isClosed              bool
+}
+
+func (w *watcher) Init(setDeadline func(time.Time) error) *watcher {
+	w.setDeadline = setDeadline
+	return w
+}
+
+func (w *watcher) Close() {
+	w.lock.Lock()
+	w.unwatch()
+	w.latestCtx = nil
+	w.isClosed = true
+	w.lock.Unlock()
+}
+
+func (w *watcher) Watch(ctx context.Context, deadline time.Time, derivesDeadline bool) {
+	deadlineIsFromContext := false
+
+	if derivesDeadline {
+		if deadline2, ok := ctx.Deadline(); ok && (deadline.IsZero() || deadline2.Before(deadline)) {
+			deadline = deadline2
+			deadlineIsFromContext = true
+		}
+	}
+
+	w.lock.Lock()
+
+	if w.isClosed {
+		w.lock.Unlock()
+		return
+	}
+
+	w.unwatch()
+	w.latestCtx = ctx
+	w.deadline = deadline
+	w.deadlineIsFromContext = deadlineIsFromContext
+	w.setDeadline(deadline)
+
+	if ctx.Done() != nil {
+		w.stop = context.AfterFunc(ctx, func() {
+			w.lock.Lock()
+
+			if ctx == w.latestCtx {
+				w.setDeadline(time.Now())
+			}
+
+			w.lock.Unlock()
+		})
+	}
+
+	w.lock.Unlock()
+}
+
+func (w *watcher) Deadline() time.Time {
+	w.lock.Lock()
+	deadline := w.deadline
+	w.lock.Unlock()
+	return deadline
+}
+
+func (w *watcher) DeadlineIsFromContext() bool {
+	w.lock.Lock()
+	deadlineIsFromContext := w.deadlineIsFromContext
+	w.lock.Unlock()
+	return deadlineIsFromContext
+}
+
+func (w *watcher) unwatch() {
+	if w.stop != nil {
+		w.stop()
+		w.stop = nil
+	}
 }
@@ -0,0 +1,302 @@
+package connection
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadCancellation(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		time.Sleep(time.Second / 20)
+		cancel()
+	}()
+
+	var buffer [1]byte
+
+	if _, err := c.Read(ctx, time.Time{}, buffer[:]); err != context.Canceled {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestWriteCancellation(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if _, err := c.Write(ctx, time.Time{}, []byte("x")); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestStaleContext(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	c.PreRead(ctx, time.Time{})
+	c.PreRead(context.Background(), time.Time{})
+	cancel()
+
+	go func() {
+		time.Sleep(time.Second / 10)
+		c2.Write([]byte("x"))
+	}()
+
+	var buffer [1]byte
+
+	if n, err := c.DoRead(context.Background(), buffer[:]); n != 1 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	var buffer [1]byte
+	_, err := c.Read(context.Background(), time.Now().Add(time.Second/20), buffer[:])
+
+	if err, ok := err.(net.Error); !ok || !err.Timeout() {
+		t.Errorf("%#v", err)
+	}
+}
+
+func BenchmarkIdleConnections(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var memStats1, memStats2 runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&memStats1)
+	numberOfGoroutines := runtime.NumGoroutine()
+	cs := make([]*Connection, b.N)
+	ps := make([]net.Conn, b.N)
+	b.ResetTimer()
+
+	for i := range cs {
+		c1, c2 := net.Pipe()
+		cs[i] = new(Connection).Init(c1)
+		cs[i].PreRead(ctx, time.Time{})
+		cs[i].PreWrite(ctx, time.Time{})
+		ps[i] = c2
+	}
+
+	b.StopTimer()
+	runtime.GC()
+	runtime.ReadMemStats(&memStats2)
+	b.ReportMetric(float64(memStats2.Sys-memStats1.Sys)/float64(b.N), "sys-B/conn")
+	b.ReportMetric(float64(runtime.NumGoroutine()-numberOfGoroutines)/float64(b.N), "goroutines/conn")
+
+	for i := range cs {
+		cs[i].Close()
+		ps[i].Close()
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	nc := c.WithContext(ctx, 0)
+
+	go func() {
+		c2.Write([]byte("hello\n"))
+	}()
+
+	if line, err := bufio.NewReader(nc).ReadString('\n'); line != "hello\n" || err != nil {
+		t.Errorf("%#v %#v", line, err)
+	}
+
+	nc.SetReadDeadline(time.Now().Add(time.Second / 20))
+	var buffer [1]byte
+	_, err := nc.Read(buffer[:])
+
+	if err, ok := err.(net.Error); !ok || !err.Timeout() {
+		t.Errorf("%#v", err)
+	}
+
+	cancel()
+
+	if _, err := nc.Write([]byte("x")); err != context.Canceled {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestWithContextTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	nc := c.WithContext(context.Background(), time.Second/20)
+	var buffer [1]byte
+	_, err := nc.Read(buffer[:])
+
+	if err, ok := err.(net.Error); !ok || !err.Timeout() {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			var buffer [1]byte
+
+			if _, err := c.Read(context.Background(), time.Time{}, buffer[:]); err != ErrConnectionClosed {
+				t.Errorf("%#v", err)
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			time.Sleep(time.Second / 20)
+			c.Close()
+		}()
+	}
+
+	wg.Wait()
+
+	if !c.IsClosed() {
+		t.Error("connection not closed")
+	}
+
+	if err := c.Close(); err != ErrConnectionClosed {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := c.Write(context.Background(), time.Time{}, []byte("x")); err != ErrConnectionClosed {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestHalfClose(t *testing.T) {
+	c1, c2 := net.Pipe()
+	c := new(Connection).Init(c1)
+
+	if err := c.CloseWrite(); err != ErrHalfCloseNotSupported {
+		t.Errorf("%#v", err)
+	}
+
+	c.Close()
+	c2.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	go func() {
+		c, err := l.Accept()
+
+		if err != nil {
+			return
+		}
+
+		data, _ := io.ReadAll(c)
+		c.Write(data)
+		c.Close()
+	}()
+
+	underlying, err := net.Dial("tcp", l.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c = new(Connection).Init(underlying)
+	defer c.Close()
+	c.Write(context.Background(), time.Time{}, []byte("hello"))
+
+	if err := c.CloseWrite(); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	if err := c.CloseWrite(); err != ErrConnectionClosed {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := c.Write(context.Background(), time.Time{}, []byte("x")); err != ErrConnectionClosed {
+		t.Errorf("%#v", err)
+	}
+
+	var buffer [5]byte
+
+	if n, err := c.ReadFull(context.Background(), time.Time{}, buffer[:]); n != 5 || err != nil || string(buffer[:]) != "hello" {
+		t.Errorf("%#v %#v", n, err)
+	}
+}
+
+func TestDeriveDeadlinesFromContext(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	c.DeriveDeadlinesFromContext()
+	var buffer [1]byte
+
+	for i := 0; i < 10; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second/100)
+
+		if _, err := c.Read(ctx, time.Time{}, buffer[:]); err != context.DeadlineExceeded {
+			t.Errorf("%#v", err)
+		}
+
+		if _, err := c.Read(ctx, time.Now().Add(time.Second), buffer[:]); err != context.DeadlineExceeded {
+			t.Errorf("%#v", err)
+		}
+
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if _, err := c.Read(ctx, time.Now().Add(time.Second/100), buffer[:]); err != ErrTimedOut {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := c.Write(context.Background(), time.Now().Add(time.Second/100), buffer[:]); err != ErrTimedOut {
+		t.Errorf("%#v", err)
+	}
+
+	if s := c.Stats(); s.NumberOfTimeouts != 22 || s.NumberOfCancels != 0 {
+		t.Errorf("%#v", s)
+	}
+
+	rl := new(RateLimiter).Init(1, 1)
+	rl.Wait(context.Background(), time.Time{}, 1)
+	c.SetWriteRateLimiters(rl)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second/100)
+	defer cancel()
+
+	if _, err := c.Write(ctx, time.Time{}, buffer[:]); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+}
@@ -0,0 +1,534 @@
+package connectiontest
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/let-z-go/toolkit/connection"
+)
+
+type FaultType int
+
+const (
+	FaultStall FaultType = 1 + iota
+	FaultReset
+	FaultEOF
+)
+
+type Fault struct {
+	Type       FaultType
+	AfterBytes int64
+	After      time.Duration
+	Duration   time.Duration
+}
+
+type Options struct {
+	Latency      time.Duration
+	Bandwidth    int64
+	BufferSize   int
+	MaxReadSize  int
+	MaxWriteSize int
+	Faults       []Fault
+}
+
+func (o *Options) normalize() {
+	if o.BufferSize < 1 {
+		o.BufferSize = defaultBufferSize
+	}
+}
+
+// NewPair returns two linked connections. options1 applies to the data sent
+// by the first connection and options2 to the data sent by the second one.
+func NewPair(options1 Options, options2 Options) (*connection.Connection, *connection.Connection) {
+	conn1, conn2 := NewConnPair(options1, options2)
+	return new(connection.Connection).Init(conn1), new(connection.Connection).Init(conn2)
+}
+
+func NewConnPair(options1 Options, options2 Options) (net.Conn, net.Conn) {
+	var l link
+	l.Streams[0].Init(&l, options1)
+	l.Streams[1].Init(&l, options2)
+	conn1 := new(conn).Init(&l.Streams[1], &l.Streams[0], "connectiontest.1", "connectiontest.2")
+	conn2 := new(conn).Init(&l.Streams[0], &l.Streams[1], "connectiontest.2", "connectiontest.1")
+	return conn1, conn2
+}
+
+var ErrConnectionReset = errors.New("toolkit/connectiontest: connection reset")
+
+const (
+	defaultBufferSize = 64 * 1024
+	maxChunkSize      = 4 * 1024
+)
+
+type link struct {
+	Streams [2]stream
+}
+
+func (l *link) Reset() {
+	for i := range l.Streams {
+		s := &l.Streams[i]
+		s.lock.Lock()
+
+		if s.err == nil {
+			s.err = ErrConnectionReset
+			s.chunks = nil
+			s.bufferedDataSize = 0
+			s.notify()
+		}
+
+		s.lock.Unlock()
+	}
+}
+
+type stream struct {
+	link             *link
+	options          Options
+	lock             sync.Mutex
+	changed          chan struct{}
+	chunks           []chunk
+	bufferedDataSize int
+	writtenDataSize  int64
+	creationTime     time.Time
+	nextSendTime     time.Time
+	stalledUntil     time.Time
+	faultIsApplied   []bool
+	faultTimers      []*time.Timer
+	isEOF            bool
+	readerIsClosed   bool
+	err              error
+}
+
+func (s *stream) Init(link *link, options Options) *stream {
+	options.normalize()
+	s.link = link
+	s.options = options
+	s.changed = make(chan struct{})
+	s.creationTime = time.Now()
+	s.faultIsApplied = make([]bool, len(options.Faults))
+
+	for _, fault := range options.Faults {
+		if fault.After >= 1 {
+			s.faultTimers = append(s.faultTimers, time.AfterFunc(fault.After, s.applyFaultsLocked))
+		}
+	}
+
+	return s
+}
+
+func (s *stream) Read(buffer []byte, deadline *deadline, closed <-chan struct{}) (int, error) {
+	for {
+		s.lock.Lock()
+
+		if s.err != nil {
+			s.lock.Unlock()
+			return 0, s.err
+		}
+
+		if isClosedChan(closed) {
+			s.lock.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+
+		if isClosedChan(deadline.Wait()) {
+			s.lock.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		if len(buffer) == 0 {
+			s.lock.Unlock()
+			return 0, nil
+		}
+
+		now := time.Now()
+		var timeout time.Duration
+
+		if len(s.chunks) >= 1 {
+			if chunk := &s.chunks[0]; !chunk.ReadyTime.After(now) {
+				maxDataSize := len(buffer)
+
+				if s.options.MaxReadSize >= 1 && maxDataSize > s.options.MaxReadSize {
+					maxDataSize = s.options.MaxReadSize
+				}
+
+				n := copy(buffer[:maxDataSize], chunk.Data)
+				chunk.Data = chunk.Data[n:]
+
+				if len(chunk.Data) == 0 {
+					s.chunks = s.chunks[1:]
+				}
+
+				s.bufferedDataSize -= n
+				s.notify()
+				s.lock.Unlock()
+				return n, nil
+			} else {
+				timeout = chunk.ReadyTime.Sub(now)
+			}
+		} else if s.isEOF {
+			s.lock.Unlock()
+			return 0, io.EOF
+		}
+
+		changed := s.changed
+		s.lock.Unlock()
+
+		if err := wait(changed, timeout, deadline, closed); err != nil && err != errWaitTimedOut {
+			return 0, err
+		}
+	}
+}
+
+func (s *stream) Write(data []byte, deadline *deadline, closed <-chan struct{}) (int, error) {
+	dataSize := 0
+
+	for dataSize < len(data) {
+		s.lock.Lock()
+
+		if s.applyFaults(time.Now()) {
+			s.lock.Unlock()
+			s.link.Reset()
+			return dataSize, ErrConnectionReset
+		}
+
+		if s.err != nil {
+			s.lock.Unlock()
+			return dataSize, s.err
+		}
+
+		if s.isEOF || s.readerIsClosed || isClosedChan(closed) {
+			s.lock.Unlock()
+			return dataSize, io.ErrClosedPipe
+		}
+
+		if isClosedChan(deadline.Wait()) {
+			s.lock.Unlock()
+			return dataSize, os.ErrDeadlineExceeded
+		}
+
+		if s.options.MaxWriteSize >= 1 && dataSize >= s.options.MaxWriteSize {
+			s.lock.Unlock()
+			return dataSize, io.ErrShortWrite
+		}
+
+		chunkSize := s.getMaxChunkSize(len(data)-dataSize, dataSize)
+
+		if chunkSize == 0 {
+			changed := s.changed
+			s.lock.Unlock()
+
+			if err := wait(changed, 0, deadline, closed); err != nil {
+				return dataSize, err
+			}
+
+			continue
+		}
+
+		now := time.Now()
+		sendTime := s.nextSendTime
+
+		if sendTime.Before(now) {
+			sendTime = now
+		}
+
+		if s.options.Bandwidth >= 1 {
+			s.nextSendTime = sendTime.Add(time.Duration(int64(chunkSize) * int64(time.Second) / s.options.Bandwidth))
+		} else {
+			s.nextSendTime = sendTime
+		}
+
+		readyTime := s.nextSendTime.Add(s.options.Latency)
+
+		if readyTime.Before(s.stalledUntil) {
+			readyTime = s.stalledUntil
+		}
+
+		s.chunks = append(s.chunks, chunk{
+			Data:      append([]byte(nil), data[dataSize:dataSize+chunkSize]...),
+			ReadyTime: readyTime,
+		})
+
+		s.bufferedDataSize += chunkSize
+		s.writtenDataSize += int64(chunkSize)
+		s.notify()
+		nextSendTime := s.nextSendTime
+		s.lock.Unlock()
+		dataSize += chunkSize
+
+		if timeout := time.Until(nextSendTime); timeout >= 1 {
+			if err := wait(nil, timeout, deadline, closed); err != nil && err != errWaitTimedOut {
+				return dataSize, err
+			}
+		}
+	}
+
+	return dataSize, nil
+}
+
+func (s *stream) CloseWriter() {
+	s.lock.Lock()
+	s.isEOF = true
+	s.notify()
+	s.lock.Unlock()
+}
+
+func (s *stream) CloseReader() {
+	s.lock.Lock()
+	s.readerIsClosed = true
+
+	for _, timer := range s.faultTimers {
+		timer.Stop()
+	}
+
+	s.notify()
+	s.lock.Unlock()
+}
+
+func (s *stream) getMaxChunkSize(limit int, dataSize int) int {
+	if s.options.MaxWriteSize >= 1 && limit > s.options.MaxWriteSize-dataSize {
+		limit = s.options.MaxWriteSize - dataSize
+	}
+
+	if n := s.options.BufferSize - s.bufferedDataSize; limit > n {
+		limit = n
+	}
+
+	if s.options.Bandwidth >= 1 && limit > maxChunkSize {
+		limit = maxChunkSize
+	}
+
+	for i, fault := range s.options.Faults {
+		if s.faultIsApplied[i] {
+			continue
+		}
+
+		if n := fault.AfterBytes - s.writtenDataSize; n >= 1 && int64(limit) > n {
+			limit = int(n)
+		}
+	}
+
+	return limit
+}
+
+func (s *stream) applyFaultsLocked() {
+	s.lock.Lock()
+	reset := s.applyFaults(time.Now())
+	s.lock.Unlock()
+
+	if reset {
+		s.link.Reset()
+	}
+}
+
+func (s *stream) applyFaults(now time.Time) bool {
+	reset := false
+
+	for i, fault := range s.options.Faults {
+		if s.faultIsApplied[i] || s.writtenDataSize < fault.AfterBytes || now.Sub(s.creationTime) < fault.After {
+			continue
+		}
+
+		s.faultIsApplied[i] = true
+
+		switch fault.Type {
+		case FaultStall:
+			s.stalledUntil = now.Add(fault.Duration)
+
+			for j := range s.chunks {
+				if chunk := &s.chunks[j]; chunk.ReadyTime.After(now) && chunk.ReadyTime.Before(s.stalledUntil) {
+					chunk.ReadyTime = s.stalledUntil
+				}
+			}
+		case FaultReset:
+			s.err = ErrConnectionReset
+			s.chunks = nil
+			s.bufferedDataSize = 0
+			reset = true
+		case FaultEOF:
+			s.isEOF = true
+		}
+
+		s.notify()
+	}
+
+	return reset
+}
+
+func (s *stream) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+type chunk struct {
+	Data      []byte
+	ReadyTime time.Time
+}
+
+type conn struct {
+	input         *stream
+	output        *stream
+	readDeadline  deadline
+	writeDeadline deadline
+	localAddr     addr
+	remoteAddr    addr
+	closeOnce     sync.Once
+	closed        chan struct{}
+}
+
+var _ net.Conn = (*conn)(nil)
+
+func (c *conn) Init(input *stream, output *stream, localAddr string, remoteAddr string) *conn {
+	c.input = input
+	c.output = output
+	c.readDeadline.Init()
+	c.writeDeadline.Init()
+	c.localAddr = addr(localAddr)
+	c.remoteAddr = addr(remoteAddr)
+	c.closed = make(chan struct{})
+	return c
+}
+
+func (c *conn) Read(buffer []byte) (int, error) {
+	return c.input.Read(buffer, &c.readDeadline, c.closed)
+}
+
+func (c *conn) Write(data []byte) (int, error) {
+	return c.output.Write(data, &c.writeDeadline, c.closed)
+}
+
+func (c *conn) Close() error {
+	err := io.ErrClosedPipe
+
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.output.CloseWriter()
+		c.input.CloseReader()
+		err = nil
+	})
+
+	return err
+}
+
+func (c *conn) CloseWrite() error {
+	c.output.CloseWriter()
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *conn) SetDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	c.writeDeadline.Set(t)
+	return nil
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	return nil
+}
+
+func (c *conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Set(t)
+	return nil
+}
+
+type addr string
+
+func (addr) Network() string  { return "connectiontest" }
+func (a addr) String() string { return string(a) }
+
+type deadline struct {
+	lock   sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func (d *deadline) Init() *deadline {
+	d.cancel = make(chan struct{})
+	return d
+}
+
+func (d *deadline) Set(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel
+	}
+
+	d.timer = nil
+	closed := isClosedChan(d.cancel)
+
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+
+		return
+	}
+
+	if timeout := time.Until(t); timeout > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+
+		cancel := d.cancel
+
+		d.timer = time.AfterFunc(timeout, func() {
+			close(cancel)
+		})
+
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) Wait() chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.cancel
+}
+
+var errWaitTimedOut = errors.New("toolkit/connectiontest: wait timed out")
+
+func wait(changed <-chan struct{}, timeout time.Duration, deadline *deadline, closed <-chan struct{}) error {
+	var timerC <-chan time.Time
+
+	if timeout >= 1 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timerC = timer.C
+	}
+
+	select {
+	case <-changed:
+		return nil
+	case <-timerC:
+		return errWaitTimedOut
+	case <-deadline.Wait():
+		return os.ErrDeadlineExceeded
+	case <-closed:
+		return io.ErrClosedPipe
+	}
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,166 @@
+package connectiontest
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPair(t *testing.T) {
+	c1, c2 := NewPair(Options{}, Options{MaxReadSize: 2})
+	defer c1.Close()
+	defer c2.Close()
+
+	if n, err := c1.Write(context.Background(), time.Time{}, []byte("hello")); n != 5 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	var buffer [5]byte
+
+	if n, err := c2.ReadFull(context.Background(), time.Time{}, buffer[:]); n != 5 || err != nil || string(buffer[:]) != "hello" {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	c2.Write(context.Background(), time.Time{}, []byte("world"))
+
+	if n, err := c1.Read(context.Background(), time.Time{}, buffer[:]); n != 2 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	_, err := c1.Read(context.Background(), time.Now().Add(-time.Second), buffer[:])
+
+	if err, ok := err.(net.Error); !ok || !err.Timeout() {
+		t.Errorf("%#v", err)
+	}
+
+	c2.Close()
+
+	if data, err := io.ReadAll(c1.WithContext(context.Background(), 0)); string(data) != "rld" || err != nil {
+		t.Errorf("%#v %#v", data, err)
+	}
+}
+
+func TestCancellation(t *testing.T) {
+	c1, c2 := NewPair(Options{BufferSize: 4}, Options{})
+	defer c1.Close()
+	defer c2.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+	var buffer [1]byte
+
+	if _, err := c1.Read(ctx, time.Time{}, buffer[:]); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if n, err := c1.Write(ctx, time.Time{}, []byte("123456")); n != 4 || err != context.DeadlineExceeded {
+		t.Errorf("%#v %#v", n, err)
+	}
+}
+
+func TestLatencyAndBandwidth(t *testing.T) {
+	c1, c2 := NewPair(Options{Latency: time.Second / 10, Bandwidth: 100 * 1024}, Options{})
+	defer c1.Close()
+	defer c2.Close()
+	startTime := time.Now()
+
+	go c1.Write(context.Background(), time.Time{}, make([]byte, 10*1024))
+
+	if data, err := readAll(c2, 10*1024); len(data) != 10*1024 || err != nil {
+		t.Errorf("%#v %#v", len(data), err)
+	}
+
+	if elapsedTime := time.Since(startTime); elapsedTime < time.Second/5 || elapsedTime > time.Second/2 {
+		t.Errorf("%#v", elapsedTime)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	c1, c2 := NewPair(Options{
+		MaxWriteSize: 8,
+		Faults: []Fault{
+			{Type: FaultStall, AfterBytes: 2, Duration: time.Second / 10},
+			{Type: FaultEOF, AfterBytes: 6},
+		},
+	}, Options{})
+
+	defer c1.Close()
+	defer c2.Close()
+
+	if n, err := c1.Write(context.Background(), time.Time{}, []byte("0123456789")); n != 6 || err != io.ErrClosedPipe {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	startTime := time.Now()
+	var buffer [2]byte
+
+	if n, err := c2.Read(context.Background(), time.Time{}, buffer[:]); n != 2 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if elapsedTime := time.Since(startTime); elapsedTime >= time.Second/20 {
+		t.Errorf("%#v", elapsedTime)
+	}
+
+	if data, err := readAll(c2, 100); string(data) != "2345" || err != nil {
+		t.Errorf("%#v %#v", data, err)
+	}
+
+	if elapsedTime := time.Since(startTime); elapsedTime < time.Second/20 {
+		t.Errorf("%#v", elapsedTime)
+	}
+
+	c1, c2 = NewPair(Options{MaxWriteSize: 3}, Options{
+		Faults: []Fault{{Type: FaultReset, After: time.Second / 20}},
+	})
+
+	defer c1.Close()
+	defer c2.Close()
+
+	if n, err := c1.Write(context.Background(), time.Time{}, []byte("0123")); n != 3 || err != io.ErrShortWrite {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if n, err := c1.Read(context.Background(), time.Time{}, buffer[:]); n != 0 || err != ErrConnectionReset {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if n, err := c2.Write(context.Background(), time.Time{}, []byte("0123")); n != 0 || err != ErrConnectionReset {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	conn1, _ := NewConnPair(Options{}, Options{})
+	conn1.SetReadDeadline(time.Now().Add(time.Second / 20))
+
+	if _, err := conn1.Read(buffer[:]); err != os.ErrDeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+}
+
+func readAll(c interface {
+	Read(context.Context, time.Time, []byte) (int, error)
+}, maxDataSize int) ([]byte, error) {
+	var buffer bytes.Buffer
+	var chunk [1024]byte
+
+	for buffer.Len() < maxDataSize {
+		n, err := c.Read(context.Background(), time.Time{}, chunk[:])
+		buffer.Write(chunk[:n])
+
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+
+			return buffer.Bytes(), err
+		}
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,233 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/let-z-go/toolkit/timerpool"
+)
+
+type DialerOptions struct {
+	Underlying         net.Dialer
+	MaxNumberOfRetries int
+	MinBackoff         time.Duration
+	MaxBackoff         time.Duration
+	BackoffFactor      float64
+	BackoffJitter      float64
+	DialInParallel     bool
+	FallbackDelay      time.Duration
+}
+
+func (do *DialerOptions) normalize() {
+	if do.MaxNumberOfRetries < 0 {
+		do.MaxNumberOfRetries = 0
+	}
+
+	if do.MinBackoff <= 0 {
+		do.MinBackoff = defaultMinBackoff
+	}
+
+	if do.MaxBackoff <= 0 {
+		do.MaxBackoff = defaultMaxBackoff
+	}
+
+	if do.MaxBackoff < do.MinBackoff {
+		do.MaxBackoff = do.MinBackoff
+	}
+
+	if do.BackoffFactor < 1 {
+		do.BackoffFactor = defaultBackoffFactor
+	}
+
+	if do.BackoffJitter < 0 {
+		do.BackoffJitter = 0
+	} else if do.BackoffJitter > 1 {
+		do.BackoffJitter = 1
+	}
+
+	if do.FallbackDelay <= 0 {
+		do.FallbackDelay = defaultFallbackDelay
+	}
+}
+
+type Dialer struct {
+	options DialerOptions
+}
+
+func (d *Dialer) Init(options DialerOptions) *Dialer {
+	options.normalize()
+	d.options = options
+	return d
+}
+
+func (d *Dialer) Dial(ctx context.Context, network string, addresses ...string) (*Connection, error) {
+	if len(addresses) == 0 {
+		return nil, &DialError{Errors: []error{errNoAddresses}}
+	}
+
+	var errs []error
+
+	for i := 0; ; i++ {
+		var underlying net.Conn
+		var errs2 []error
+
+		if d.options.DialInParallel {
+			underlying, errs2 = d.dialInParallel(ctx, network, addresses)
+		} else {
+			underlying, errs2 = d.dialInSequence(ctx, network, addresses)
+		}
+
+		if underlying != nil {
+			return new(Connection).Init(underlying), nil
+		}
+
+		errs = append(errs, errs2...)
+
+		if i == d.options.MaxNumberOfRetries {
+			break
+		}
+
+		timer := timerpool.GetTimer(d.getBackoff(i))
+
+		select {
+		case <-timer.C:
+			timerpool.PutTimer(timer)
+		case <-ctx.Done():
+			timerpool.StopAndPutTimer(timer)
+			return nil, &DialError{Errors: append(errs, ctx.Err())}
+		}
+	}
+
+	return nil, &DialError{Errors: errs}
+}
+
+func (d *Dialer) dialInSequence(ctx context.Context, network string, addresses []string) (net.Conn, []error) {
+	var errs []error
+
+	for _, address := range addresses {
+		underlying, err := d.options.Underlying.DialContext(ctx, network, address)
+
+		if err == nil {
+			return underlying, nil
+		}
+
+		errs = append(errs, err)
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return nil, errs
+}
+
+func (d *Dialer) dialInParallel(ctx context.Context, network string, addresses []string) (net.Conn, []error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(addresses))
+	failures := make(chan struct{}, len(addresses))
+
+	for i, address := range addresses {
+		go func(i int, address string) {
+			if i >= 1 {
+				timer := timerpool.GetTimer(time.Duration(i) * d.options.FallbackDelay)
+
+				select {
+				case <-timer.C:
+					timerpool.PutTimer(timer)
+				case <-failures:
+					timerpool.StopAndPutTimer(timer)
+				case <-ctx.Done():
+					timerpool.StopAndPutTimer(timer)
+					results <- dialResult{Err: ctx.Err()}
+					return
+				}
+			}
+
+			underlying, err := d.options.Underlying.DialContext(ctx, network, address)
+
+			if err != nil {
+				failures <- struct{}{}
+			}
+
+			results <- dialResult{underlying, err}
+		}(i, address)
+	}
+
+	var underlying net.Conn
+	var errs []error
+
+	for range addresses {
+		result := <-results
+
+		if result.Err != nil {
+			errs = append(errs, result.Err)
+			continue
+		}
+
+		if underlying == nil {
+			underlying = result.Underlying
+			cancel()
+		} else {
+			result.Underlying.Close()
+		}
+	}
+
+	if underlying != nil {
+		return underlying, nil
+	}
+
+	return nil, errs
+}
+
+func (d *Dialer) getBackoff(retryIndex int) time.Duration {
+	backoff := float64(d.options.MinBackoff)
+
+	for i := 0; i < retryIndex && backoff < float64(d.options.MaxBackoff); i++ {
+		backoff *= d.options.BackoffFactor
+	}
+
+	if backoff > float64(d.options.MaxBackoff) {
+		backoff = float64(d.options.MaxBackoff)
+	}
+
+	backoff *= 1 + d.options.BackoffJitter*(2*rand.Float64()-1)
+	return time.Duration(backoff)
+}
+
+type DialError struct {
+	Errors []error
+}
+
+func (de *DialError) Error() string {
+	messages := make([]string, len(de.Errors))
+
+	for i, err := range de.Errors {
+		messages[i] = err.Error()
+	}
+
+	return fmt.Sprintf("toolkit/connection: dial failed: %s", strings.Join(messages, "; "))
+}
+
+func (de *DialError) Unwrap() []error {
+	return de.Errors
+}
+
+const (
+	defaultMinBackoff    = 100 * time.Millisecond
+	defaultMaxBackoff    = 10 * time.Second
+	defaultBackoffFactor = 2
+	defaultFallbackDelay = 300 * time.Millisecond
+)
+
+var errNoAddresses = errors.New("toolkit/connection: no addresses")
+
+type dialResult struct {
+	Underlying net.Conn
+	Err        error
+}
@@ -0,0 +1,104 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDialer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			c.Close()
+		}
+	}()
+
+	deadAddress := closedAddress(t)
+
+	for _, dialInParallel := range []bool{false, true} {
+		d := new(Dialer).Init(DialerOptions{
+			DialInParallel: dialInParallel,
+			FallbackDelay:  time.Second,
+		})
+
+		startTime := time.Now()
+		c, err := d.Dial(context.Background(), "tcp", deadAddress, l.Addr().String())
+
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+
+		if elapsedTime := time.Since(startTime); elapsedTime >= time.Second {
+			t.Errorf("%#v", elapsedTime)
+		}
+
+		if a := c.underlying.RemoteAddr().String(); a != l.Addr().String() {
+			t.Errorf("%#v", a)
+		}
+
+		c.Close()
+	}
+}
+
+func TestDialerRetry(t *testing.T) {
+	deadAddress := closedAddress(t)
+
+	d := new(Dialer).Init(DialerOptions{
+		MaxNumberOfRetries: 2,
+		MinBackoff:         time.Second / 50,
+		DialInParallel:     true,
+	})
+
+	startTime := time.Now()
+	_, err := d.Dial(context.Background(), "tcp", deadAddress, deadAddress)
+
+	if elapsedTime := time.Since(startTime); elapsedTime < 3*time.Second/50 {
+		t.Errorf("%#v", elapsedTime)
+	}
+
+	var de *DialError
+
+	if !errors.As(err, &de) || len(de.Errors) != 6 || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("%#v", err)
+	}
+
+	d = new(Dialer).Init(DialerOptions{
+		MaxNumberOfRetries: 100,
+		MinBackoff:         time.Second,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if _, err := d.Dial(ctx, "tcp", deadAddress); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("%#v", err)
+	}
+}
+
+func closedAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address := l.Addr().String()
+	l.Close()
+	return address
+}
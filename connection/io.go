@@ -0,0 +1,81 @@
+package connection
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+)
+
+func (c *Connection) ReadFull(ctx context.Context, deadline time.Time, buffer []byte) (int, error) {
+	return c.ReadAtLeast(ctx, deadline, buffer, len(buffer))
+}
+
+func (c *Connection) ReadAtLeast(ctx context.Context, deadline time.Time, buffer []byte, minDataSize int) (int, error) {
+	if len(buffer) < minDataSize {
+		return 0, io.ErrShortBuffer
+	}
+
+	c.PreRead(ctx, deadline)
+	dataSize := 0
+
+	for dataSize < minDataSize {
+		n, err := c.DoRead(ctx, buffer[dataSize:])
+		dataSize += n
+
+		if err != nil {
+			if dataSize >= minDataSize {
+				break
+			}
+
+			if err == io.EOF && dataSize >= 1 {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return dataSize, convertTimeoutError(err)
+		}
+	}
+
+	return dataSize, nil
+}
+
+func (c *Connection) WriteAll(ctx context.Context, deadline time.Time, data []byte) (int, error) {
+	c.PreWrite(ctx, deadline)
+	dataSize := 0
+
+	for dataSize < len(data) {
+		n, err := c.DoWrite(ctx, data[dataSize:])
+		dataSize += n
+
+		if err != nil {
+			return dataSize, convertTimeoutError(err)
+		}
+
+		if n == 0 {
+			return dataSize, io.ErrShortWrite
+		}
+	}
+
+	return dataSize, nil
+}
+
+// ErrTimedOut is a net.Error whose Timeout method returns true.
+var ErrTimedOut error = timedOutError{}
+
+type timedOutError struct{}
+
+func (timedOutError) Error() string   { return "toolkit/connection: timed out" }
+func (timedOutError) Timeout() bool   { return true }
+func (timedOutError) Temporary() bool { return true }
+
+func convertTimeoutError(err error) error {
+	if err == context.DeadlineExceeded {
+		return err
+	}
+
+	if err, ok := err.(net.Error); ok && err.Timeout() {
+		return ErrTimedOut
+	}
+
+	return err
+}
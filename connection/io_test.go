@@ -0,0 +1,148 @@
+package connection
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadFull(t *testing.T) {
+	c1, c2 := net.Pipe()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+
+	go func() {
+		c2.Write([]byte("12"))
+		c2.Write([]byte("345"))
+		c2.Write([]byte("6"))
+		c2.Close()
+	}()
+
+	var buffer [4]byte
+
+	if n, err := c.ReadFull(context.Background(), time.Time{}, buffer[:]); n != 4 || err != nil || string(buffer[:]) != "1234" {
+		t.Errorf("%#v %#v %#v", n, err, buffer)
+	}
+
+	if n, err := c.ReadFull(context.Background(), time.Time{}, buffer[:]); n != 2 || err != io.ErrUnexpectedEOF {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if n, err := c.ReadFull(context.Background(), time.Time{}, buffer[:]); n != 0 || err != io.EOF {
+		t.Errorf("%#v %#v", n, err)
+	}
+}
+
+func TestReadAtLeast(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+
+	go func() {
+		c2.Write([]byte("12"))
+	}()
+
+	var buffer [4]byte
+
+	if n, err := c.ReadAtLeast(context.Background(), time.Time{}, buffer[:], 5); n != 0 || err != io.ErrShortBuffer {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if n, err := c.ReadAtLeast(context.Background(), time.Now().Add(time.Second/20), buffer[:], 3); n != 2 || err != ErrTimedOut {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if n, err := c.ReadAtLeast(ctx, time.Time{}, buffer[:], 1); n != 0 || err != context.DeadlineExceeded {
+		t.Errorf("%#v %#v", n, err)
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+
+	go func() {
+		var buffer [3]byte
+		io.ReadFull(c2, buffer[:])
+	}()
+
+	if n, err := c.WriteAll(context.Background(), time.Now().Add(time.Second/10), []byte("123456")); n != 3 || err != ErrTimedOut {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if n, err := c.WriteAll(ctx, time.Time{}, []byte("123456")); n != 0 || err != context.Canceled {
+		t.Errorf("%#v %#v", n, err)
+	}
+}
+
+func TestWriteBuffers(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+	data := make(chan []byte, 1)
+
+	go func() {
+		c, err := l.Accept()
+
+		if err != nil {
+			return
+		}
+
+		data2, _ := io.ReadAll(c)
+		data <- data2
+		c.Close()
+	}()
+
+	underlying, err := net.Dial("tcp", l.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := new(Connection).Init(underlying)
+	buffers := net.Buffers{[]byte("head"), []byte("er"), []byte("payload")}
+
+	if n, err := c.WriteBuffers(context.Background(), time.Time{}, buffers); n != 13 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if len(buffers) != 3 || string(buffers[2]) != "payload" {
+		t.Errorf("%#v", buffers)
+	}
+
+	if s := c.Stats(); s.NumberOfBytesWritten != 13 || s.NumberOfWrites != 1 {
+		t.Errorf("%#v", s)
+	}
+
+	c.Close()
+
+	if data2 := <-data; string(data2) != "headerpayload" {
+		t.Errorf("%#v", data2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c = new(Connection).Init(c1)
+	defer c.Close()
+
+	if n, err := c.WriteBuffers(ctx, time.Time{}, buffers); n != 0 || err != context.Canceled {
+		t.Errorf("%#v %#v", n, err)
+	}
+}
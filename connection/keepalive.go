@@ -0,0 +1,221 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type KeepaliveOptions struct {
+	IdleTimeout       time.Duration
+	HeartbeatInterval time.Duration
+	HeartbeatTimeout  time.Duration
+	Heartbeat         func(ctx context.Context, connection *Connection) error
+	OnClose           func(connection *Connection, reason error)
+}
+
+func (ko *KeepaliveOptions) normalize() {
+	if ko.Heartbeat == nil {
+		ko.HeartbeatInterval = 0
+		ko.HeartbeatTimeout = 0
+		return
+	}
+
+	if ko.HeartbeatInterval <= 0 {
+		ko.HeartbeatInterval = defaultHeartbeatInterval
+	}
+
+	if ko.HeartbeatTimeout <= 0 {
+		ko.HeartbeatTimeout = ko.HeartbeatInterval
+	}
+}
+
+// StartKeepalive must be called before the connection is used. Traffic
+// produced by the heartbeat counts as activity, so a connection whose peer
+// answers heartbeats never becomes idle. Heartbeat is called on a timer
+// goroutine; if the application may be writing at the same time, it must send
+// the heartbeat through the application's own writer (such as a
+// BufferedWriter) rather than call Write or PreWrite directly.
+func (c *Connection) StartKeepalive(options KeepaliveOptions) {
+	options.normalize()
+	k := &keepalive{
+		connection: c,
+		options:    options,
+	}
+
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&c.lastReadTime, now)
+	atomic.StoreInt64(&c.lastWriteTime, now)
+	c.keepalive = k
+
+	if options.IdleTimeout >= 1 || options.Heartbeat != nil {
+		k.lock.Lock()
+		k.timer = time.AfterFunc(k.check(now), k.onTimer)
+		k.lock.Unlock()
+	}
+}
+
+func (c *Connection) LastActivityTime() time.Time {
+	lastReadTime := atomic.LoadInt64(&c.lastReadTime)
+	lastWriteTime := atomic.LoadInt64(&c.lastWriteTime)
+
+	if lastReadTime < lastWriteTime {
+		lastReadTime = lastWriteTime
+	}
+
+	return time.Unix(0, lastReadTime)
+}
+
+func (c *Connection) abort(reason error) {
+	c.abortReason.Store(reasonHolder{reason})
+
+	if c.setCloseFlags(closeFlagClosed | closeFlagReadClosed | closeFlagWriteClosed) {
+		c.doClose()
+	}
+}
+
+func (c *Connection) getAbortReason() error {
+	if value := c.abortReason.Load(); value != nil {
+		return value.(reasonHolder).Reason
+	}
+
+	return nil
+}
+
+var (
+	ErrIdleTimedOut    = errors.New("toolkit/connection: idle timed out")
+	ErrHeartbeatMissed = errors.New("toolkit/connection: heartbeat missed")
+	ErrPeerClosed      = errors.New("toolkit/connection: peer closed")
+)
+
+const defaultHeartbeatInterval = 30 * time.Second
+
+type keepalive struct {
+	connection      *Connection
+	options         KeepaliveOptions
+	lock            sync.Mutex
+	timer           *time.Timer
+	isStopped       bool
+	heartbeatSentAt int64
+}
+
+func (k *keepalive) onTimer() {
+	now := time.Now().UnixNano()
+	timeout := k.check(now)
+
+	if timeout < 0 {
+		return
+	}
+
+	k.lock.Lock()
+
+	if !k.isStopped {
+		k.timer.Reset(timeout)
+	}
+
+	k.lock.Unlock()
+}
+
+func (k *keepalive) check(now int64) time.Duration {
+	c := k.connection
+	lastReadTime := atomic.LoadInt64(&c.lastReadTime)
+	lastActivityTime := c.LastActivityTime().UnixNano()
+	nextCheckTime := int64(0)
+
+	if k.options.IdleTimeout >= 1 {
+		idleDeadline := lastActivityTime + int64(k.options.IdleTimeout)
+
+		if now >= idleDeadline {
+			k.close(ErrIdleTimedOut)
+			return -1
+		}
+
+		nextCheckTime = idleDeadline
+	}
+
+	if k.options.Heartbeat != nil {
+		if k.heartbeatSentAt >= 1 && lastReadTime > k.heartbeatSentAt {
+			k.heartbeatSentAt = 0
+		}
+
+		if k.heartbeatSentAt >= 1 {
+			heartbeatDeadline := k.heartbeatSentAt + int64(k.options.HeartbeatTimeout)
+
+			if now >= heartbeatDeadline {
+				k.close(ErrHeartbeatMissed)
+				return -1
+			}
+
+			nextCheckTime = minNonZero(nextCheckTime, heartbeatDeadline)
+		} else {
+			heartbeatTime := lastActivityTime + int64(k.options.HeartbeatInterval)
+
+			if now >= heartbeatTime {
+				k.heartbeatSentAt = now
+				ctx, cancel := context.WithTimeout(context.Background(), k.options.HeartbeatTimeout)
+				err := k.options.Heartbeat(ctx, c)
+				cancel()
+
+				if err != nil {
+					k.close(ErrHeartbeatMissed)
+					return -1
+				}
+
+				heartbeatTime = k.heartbeatSentAt + int64(k.options.HeartbeatTimeout)
+			}
+
+			nextCheckTime = minNonZero(nextCheckTime, heartbeatTime)
+		}
+	}
+
+	return time.Duration(nextCheckTime - now)
+}
+
+func (k *keepalive) onPeerClosed() {
+	k.close(ErrPeerClosed)
+}
+
+func (k *keepalive) close(reason error) {
+	if !k.stop() {
+		return
+	}
+
+	if reason != ErrPeerClosed && reason != nil {
+		k.connection.abort(reason)
+	}
+
+	if k.options.OnClose != nil {
+		k.options.OnClose(k.connection, reason)
+	}
+}
+
+func (k *keepalive) stop() bool {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if k.isStopped {
+		return false
+	}
+
+	k.isStopped = true
+
+	if k.timer != nil {
+		k.timer.Stop()
+	}
+
+	return true
+}
+
+type reasonHolder struct {
+	Reason error
+}
+
+func minNonZero(x int64, y int64) int64 {
+	if x == 0 || y < x {
+		return y
+	}
+
+	return x
+}
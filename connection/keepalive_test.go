@@ -0,0 +1,127 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	reasons := make(chan error, 1)
+
+	c.StartKeepalive(KeepaliveOptions{
+		IdleTimeout: time.Second / 10,
+		OnClose: func(_ *Connection, reason error) {
+			reasons <- reason
+		},
+	})
+
+	var buffer [1]byte
+
+	if _, err := c.Read(context.Background(), time.Time{}, buffer[:]); err != ErrIdleTimedOut {
+		t.Errorf("%#v", err)
+	}
+
+	if reason := <-reasons; reason != ErrIdleTimedOut {
+		t.Errorf("%#v", reason)
+	}
+
+	if !c.IsClosed() {
+		t.Error("not closed")
+	}
+
+	if _, err := c.Write(context.Background(), time.Time{}, buffer[:]); err != ErrIdleTimedOut {
+		t.Errorf("%#v", err)
+	}
+
+	if err := c.Close(); err != ErrConnectionClosed {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	reasons := make(chan error, 1)
+	numberOfHeartbeats := int32(0)
+	pongs := int32(2)
+
+	go func() {
+		var buffer [4]byte
+
+		for {
+			if _, err := c2.Read(buffer[:]); err != nil {
+				return
+			}
+
+			if atomic.AddInt32(&pongs, -1) >= 0 {
+				c2.Write([]byte("pong"))
+			}
+		}
+	}()
+
+	c.StartKeepalive(KeepaliveOptions{
+		IdleTimeout:       time.Second,
+		HeartbeatInterval: time.Second / 20,
+		HeartbeatTimeout:  time.Second / 20,
+		Heartbeat: func(ctx context.Context, c *Connection) error {
+			atomic.AddInt32(&numberOfHeartbeats, 1)
+			deadline, _ := ctx.Deadline()
+			_, err := c.Write(ctx, deadline, []byte("ping"))
+			return err
+		},
+		OnClose: func(_ *Connection, reason error) {
+			reasons <- reason
+		},
+	})
+
+	var buffer [4]byte
+
+	for {
+		if _, err := c.Read(context.Background(), time.Time{}, buffer[:]); err != nil {
+			if err != ErrHeartbeatMissed {
+				t.Errorf("%#v", err)
+			}
+
+			break
+		}
+	}
+
+	if reason := <-reasons; reason != ErrHeartbeatMissed {
+		t.Errorf("%#v", reason)
+	}
+
+	if n := atomic.LoadInt32(&numberOfHeartbeats); n != 3 {
+		t.Errorf("%#v", n)
+	}
+}
+
+func TestPeerClosed(t *testing.T) {
+	c1, c2 := net.Pipe()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	reasons := make(chan error, 1)
+
+	c.StartKeepalive(KeepaliveOptions{
+		IdleTimeout: time.Second,
+		OnClose: func(_ *Connection, reason error) {
+			reasons <- reason
+		},
+	})
+
+	c2.Close()
+	var buffer [1]byte
+	c.Read(context.Background(), time.Time{}, buffer[:])
+
+	if reason := <-reasons; reason != ErrPeerClosed {
+		t.Errorf("%#v", reason)
+	}
+}
@@ -0,0 +1,337 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/let-z-go/toolkit/semaphore"
+)
+
+type ListenerOptions struct {
+	MaxNumberOfConnections int
+	RejectWhenFull         bool
+}
+
+func (lo *ListenerOptions) normalize() {
+	if lo.MaxNumberOfConnections < 1 {
+		lo.MaxNumberOfConnections = maxInt
+	}
+}
+
+type Listener struct {
+	underlying         net.Listener
+	options            ListenerOptions
+	acceptWatcher      acceptWatcher
+	semaphore          semaphore.Semaphore
+	lock               sync.Mutex
+	connections        map[*Connection]struct{}
+	drained            chan struct{}
+	isShutDown         int32
+	numberOfRejections int64
+}
+
+func (l *Listener) Init(underlying net.Listener, options ListenerOptions) *Listener {
+	options.normalize()
+	l.underlying = underlying
+	l.options = options
+
+	if underlying, ok := underlying.(interface{ SetDeadline(time.Time) error }); ok {
+		l.acceptWatcher.Init(underlying.SetDeadline)
+	}
+
+	l.semaphore.Init(0, options.MaxNumberOfConnections, 0)
+	l.connections = map[*Connection]struct{}{}
+	l.drained = make(chan struct{})
+	return l
+}
+
+// Accept may be called concurrently; cancelling ctx only interrupts the call
+// it was passed to. Interrupting a call in progress requires the underlying
+// listener to implement SetDeadline (as *net.TCPListener and *net.UnixListener
+// do); otherwise cancelling ctx does not unblock a pending accept, and only
+// Close does.
+func (l *Listener) Accept(ctx context.Context) (*Connection, error) {
+	for {
+		if l.IsShutDown() {
+			return nil, ErrListenerClosed
+		}
+
+		if !l.options.RejectWhenFull {
+			if err := l.semaphore.Up(ctx, false, nil); err != nil {
+				return nil, l.convertSemaphoreError(err)
+			}
+		}
+
+		underlying, err := l.doAccept(ctx)
+
+		if err != nil {
+			if !l.options.RejectWhenFull {
+				l.semaphore.Down(context.Background(), false, nil)
+			}
+
+			return nil, err
+		}
+
+		if l.options.RejectWhenFull {
+			if err := l.semaphore.Up(cancelledCtx, false, nil); err != nil {
+				underlying.Close()
+				atomic.AddInt64(&l.numberOfRejections, 1)
+
+				if err == semaphore.ErrSemaphoreClosed {
+					return nil, ErrListenerClosed
+				}
+
+				continue
+			}
+		}
+
+		connection := new(Connection).Init(underlying)
+
+		if !l.addConnection(connection) {
+			underlying.Close()
+			l.semaphore.Down(context.Background(), false, nil)
+			return nil, ErrListenerClosed
+		}
+
+		return connection, nil
+	}
+}
+
+func (l *Listener) Shutdown(ctx context.Context) error {
+	l.stopAccepting()
+
+	select {
+	case <-l.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *Listener) Close() error {
+	err := l.stopAccepting()
+	l.lock.Lock()
+	connections := make([]*Connection, 0, len(l.connections))
+
+	for connection := range l.connections {
+		connections = append(connections, connection)
+	}
+
+	l.lock.Unlock()
+
+	for _, connection := range connections {
+		connection.Close()
+	}
+
+	return err
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.underlying.Addr()
+}
+
+func (l *Listener) IsShutDown() bool {
+	return atomic.LoadInt32(&l.isShutDown) == 1
+}
+
+func (l *Listener) NumberOfConnections() int {
+	l.lock.Lock()
+	numberOfConnections := len(l.connections)
+	l.lock.Unlock()
+	return numberOfConnections
+}
+
+func (l *Listener) NumberOfRejections() int64 {
+	return atomic.LoadInt64(&l.numberOfRejections)
+}
+
+func (l *Listener) doAccept(ctx context.Context) (net.Conn, error) {
+	for {
+		var wait *acceptWait
+
+		if l.acceptWatcher.setDeadline != nil {
+			wait = l.acceptWatcher.Watch(ctx)
+		}
+
+		underlying, err := l.underlying.Accept()
+
+		if wait != nil {
+			l.acceptWatcher.Unwatch(wait)
+		}
+
+		if err == nil {
+			return underlying, nil
+		}
+
+		if err2 := ctx.Err(); err2 != nil {
+			return nil, err2
+		}
+
+		if l.IsShutDown() {
+			return nil, ErrListenerClosed
+		}
+
+		if err2, ok := err.(net.Error); ok && err2.Timeout() && wait != nil {
+			// the deadline was set to interrupt another call to Accept
+			if err := l.acceptWatcher.WaitForCancels(ctx); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		return nil, err
+	}
+}
+
+func (l *Listener) stopAccepting() error {
+	l.lock.Lock()
+
+	if l.IsShutDown() {
+		l.lock.Unlock()
+		return ErrListenerClosed
+	}
+
+	atomic.StoreInt32(&l.isShutDown, 1)
+
+	if len(l.connections) == 0 {
+		close(l.drained)
+	}
+
+	l.lock.Unlock()
+	l.semaphore.Close(nil)
+	return l.underlying.Close()
+}
+
+func (l *Listener) addConnection(connection *Connection) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.IsShutDown() {
+		return false
+	}
+
+	l.connections[connection] = struct{}{}
+	connection.onClosed = func() { l.removeConnection(connection) }
+	return true
+}
+
+func (l *Listener) removeConnection(connection *Connection) {
+	l.lock.Lock()
+	delete(l.connections, connection)
+
+	if len(l.connections) == 0 && l.IsShutDown() {
+		close(l.drained)
+	}
+
+	l.lock.Unlock()
+	l.semaphore.Down(context.Background(), false, nil)
+}
+
+func (l *Listener) convertSemaphoreError(err error) error {
+	if err == semaphore.ErrSemaphoreClosed {
+		return ErrListenerClosed
+	}
+
+	return err
+}
+
+var ErrListenerClosed = errors.New("toolkit/connection: listener closed")
+
+const maxInt = int(^uint(0) >> 1)
+
+var cancelledCtx = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
+
+// acceptWatcher interrupts the calls to Accept whose contexts are done by
+// setting the deadline of the listener to the past, which interrupts the other
+// calls as well. The deadline is not reset until all the interrupted calls
+// have returned.
+type acceptWatcher struct {
+	lock              sync.Mutex
+	setDeadline       func(time.Time) error
+	numberOfCancels   int
+	cancelsAreCleared chan struct{}
+}
+
+type acceptWait struct {
+	isDone      bool
+	isCancelled bool
+	stop        func() bool
+}
+
+func (aw *acceptWatcher) Init(setDeadline func(time.Time) error) *acceptWatcher {
+	aw.setDeadline = setDeadline
+	return aw
+}
+
+func (aw *acceptWatcher) Watch(ctx context.Context) *acceptWait {
+	wait := new(acceptWait)
+
+	if ctx.Done() != nil {
+		wait.stop = context.AfterFunc(ctx, func() {
+			aw.lock.Lock()
+
+			if !wait.isDone {
+				wait.isCancelled = true
+
+				if aw.numberOfCancels == 0 {
+					aw.cancelsAreCleared = make(chan struct{})
+					aw.setDeadline(time.Now())
+				}
+
+				aw.numberOfCancels++
+			}
+
+			aw.lock.Unlock()
+		})
+	}
+
+	return wait
+}
+
+func (aw *acceptWatcher) Unwatch(wait *acceptWait) {
+	if wait.stop != nil {
+		wait.stop()
+	}
+
+	aw.lock.Lock()
+	wait.isDone = true
+
+	if wait.isCancelled {
+		aw.numberOfCancels--
+
+		if aw.numberOfCancels == 0 {
+			aw.setDeadline(time.Time{})
+			close(aw.cancelsAreCleared)
+		}
+	}
+
+	aw.lock.Unlock()
+}
+
+func (aw *acceptWatcher) WaitForCancels(ctx context.Context) error {
+	aw.lock.Lock()
+
+	if aw.numberOfCancels == 0 {
+		aw.lock.Unlock()
+		return nil
+	}
+
+	cancelsAreCleared := aw.cancelsAreCleared
+	aw.lock.Unlock()
+
+	select {
+	case <-cancelsAreCleared:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,164 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListener(t *testing.T) {
+	l := newTestListener(t, ListenerOptions{MaxNumberOfConnections: 2})
+	defer l.Close()
+	address := l.Addr().String()
+	var cs []*Connection
+
+	for i := 0; i < 2; i++ {
+		go net.Dial("tcp", address)
+		c, err := l.Accept(context.Background())
+
+		if err != nil {
+			t.Fatalf("%#v", err)
+		}
+
+		cs = append(cs, c)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if _, err := l.Accept(ctx); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+
+	go func() {
+		time.Sleep(time.Second / 20)
+		cs[0].Close()
+		net.Dial("tcp", address)
+	}()
+
+	c, err := l.Accept(context.Background())
+
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	cs[0] = c
+
+	if n := l.NumberOfConnections(); n != 2 {
+		t.Errorf("%#v", n)
+	}
+}
+
+func TestListenerRejectWhenFull(t *testing.T) {
+	l := newTestListener(t, ListenerOptions{MaxNumberOfConnections: 1, RejectWhenFull: true})
+	defer l.Close()
+	address := l.Addr().String()
+	go net.Dial("tcp", address)
+
+	if _, err := l.Accept(context.Background()); err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	c, err := net.Dial("tcp", address)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/10)
+	defer cancel()
+
+	if _, err := l.Accept(ctx); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+
+	var buffer [1]byte
+
+	if _, err := c.Read(buffer[:]); err == nil {
+		t.Errorf("%#v", err)
+	}
+
+	if n := l.NumberOfRejections(); n != 1 {
+		t.Errorf("%#v", n)
+	}
+}
+
+func TestListenerShutdown(t *testing.T) {
+	l := newTestListener(t, ListenerOptions{})
+	go net.Dial("tcp", l.Addr().String())
+	c, err := l.Accept(context.Background())
+
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if err := l.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := l.Accept(context.Background()); err != ErrListenerClosed {
+		t.Errorf("%#v", err)
+	}
+
+	go func() {
+		time.Sleep(time.Second / 20)
+		c.Close()
+	}()
+
+	if err := l.Shutdown(context.Background()); err != nil {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestListenerConcurrentAccept(t *testing.T) {
+	l := newTestListener(t, ListenerOptions{})
+	defer l.Close()
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	errs1 := make(chan error, 1)
+	errs2 := make(chan error, 1)
+
+	go func() {
+		_, err := l.Accept(ctx1)
+		errs1 <- err
+	}()
+
+	go func() {
+		_, err := l.Accept(ctx2)
+		errs2 <- err
+	}()
+
+	time.Sleep(time.Second / 20)
+	cancel1()
+
+	if err := <-errs1; err != context.Canceled {
+		t.Errorf("%#v", err)
+	}
+
+	select {
+	case err := <-errs2:
+		t.Fatalf("%#v", err)
+	case <-time.After(time.Second / 20):
+	}
+
+	go net.Dial("tcp", l.Addr().String())
+
+	if err := <-errs2; err != nil {
+		t.Errorf("%#v", err)
+	}
+}
+
+func newTestListener(t *testing.T, options ListenerOptions) *Listener {
+	underlying, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return new(Listener).Init(underlying, options)
+}
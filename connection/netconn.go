@@ -0,0 +1,89 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+func (c *Connection) WithContext(ctx context.Context, timeout time.Duration) net.Conn {
+	return &netConn{
+		connection: c,
+		ctx:        ctx,
+		timeout:    timeout,
+	}
+}
+
+type netConn struct {
+	connection    *Connection
+	ctx           context.Context
+	timeout       time.Duration
+	lock          sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+var _ net.Conn = (*netConn)(nil)
+
+func (nc *netConn) Read(buffer []byte) (int, error) {
+	nc.lock.Lock()
+	deadline := nc.makeDeadline(nc.readDeadline)
+	nc.lock.Unlock()
+	return nc.connection.Read(nc.ctx, deadline, buffer)
+}
+
+func (nc *netConn) Write(data []byte) (int, error) {
+	nc.lock.Lock()
+	deadline := nc.makeDeadline(nc.writeDeadline)
+	nc.lock.Unlock()
+	return nc.connection.Write(nc.ctx, deadline, data)
+}
+
+func (nc *netConn) Close() error {
+	return nc.connection.Close()
+}
+
+func (nc *netConn) LocalAddr() net.Addr {
+	return nc.connection.underlying.LocalAddr()
+}
+
+func (nc *netConn) RemoteAddr() net.Addr {
+	return nc.connection.underlying.RemoteAddr()
+}
+
+func (nc *netConn) SetDeadline(deadline time.Time) error {
+	nc.SetReadDeadline(deadline)
+	nc.SetWriteDeadline(deadline)
+	return nil
+}
+
+func (nc *netConn) SetReadDeadline(deadline time.Time) error {
+	nc.lock.Lock()
+	nc.readDeadline = deadline
+	nc.connection.PreRead(nc.ctx, nc.makeDeadline(deadline))
+	nc.lock.Unlock()
+	return nil
+}
+
+func (nc *netConn) SetWriteDeadline(deadline time.Time) error {
+	nc.lock.Lock()
+	nc.writeDeadline = deadline
+	nc.connection.PreWrite(nc.ctx, nc.makeDeadline(deadline))
+	nc.lock.Unlock()
+	return nil
+}
+
+func (nc *netConn) makeDeadline(deadline time.Time) time.Time {
+	if nc.timeout <= 0 {
+		return deadline
+	}
+
+	deadline2 := time.Now().Add(nc.timeout)
+
+	if deadline.IsZero() || deadline2.Before(deadline) {
+		return deadline2
+	}
+
+	return deadline
+}
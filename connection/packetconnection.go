@@ -0,0 +1,113 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/let-z-go/toolkit/utils"
+)
+
+type PacketConnection struct {
+	isClosed         int32
+	counters         counters
+	underlying       net.PacketConn
+	readWatcher      watcher
+	writeWatcher     watcher
+	statsGroup       *StatsGroup
+	derivesDeadlines bool
+}
+
+func (pc *PacketConnection) Init(underlying net.PacketConn) *PacketConnection {
+	utils.Assert(underlying != nil, func() string {
+		return "toolkit/connection: invalid argument: underlying is nil"
+	})
+
+	pc.underlying = underlying
+	pc.readWatcher.Init(underlying.SetReadDeadline)
+	pc.writeWatcher.Init(underlying.SetWriteDeadline)
+	return pc
+}
+
+func (pc *PacketConnection) Close() error {
+	if !atomic.CompareAndSwapInt32(&pc.isClosed, 0, 1) {
+		return ErrConnectionClosed
+	}
+
+	pc.readWatcher.Close()
+	pc.writeWatcher.Close()
+	return pc.underlying.Close()
+}
+
+// JoinStatsGroup and DeriveDeadlinesFromContext must be called before the
+// connection is used.
+func (pc *PacketConnection) JoinStatsGroup(statsGroup *StatsGroup) {
+	pc.statsGroup = statsGroup
+}
+
+func (pc *PacketConnection) DeriveDeadlinesFromContext() {
+	pc.derivesDeadlines = true
+}
+
+func (pc *PacketConnection) ReadFrom(ctx context.Context, deadline time.Time, buffer []byte) (int, net.Addr, error) {
+	if pc.IsClosed() {
+		return 0, nil, ErrConnectionClosed
+	}
+
+	pc.readWatcher.Watch(ctx, deadline, pc.derivesDeadlines)
+	startTime := time.Now()
+	n, addr, err := pc.underlying.ReadFrom(buffer)
+	blockingTime := time.Since(startTime)
+
+	if err != nil {
+		err = pc.convertError(ctx, err, &pc.readWatcher)
+	}
+
+	pc.counters.AddRead(n, err, blockingTime)
+
+	if pc.statsGroup != nil {
+		pc.statsGroup.counters.AddRead(n, err, blockingTime)
+	}
+
+	return n, addr, err
+}
+
+func (pc *PacketConnection) WriteTo(ctx context.Context, deadline time.Time, data []byte, addr net.Addr) (int, error) {
+	if pc.IsClosed() {
+		return 0, ErrConnectionClosed
+	}
+
+	pc.writeWatcher.Watch(ctx, deadline, pc.derivesDeadlines)
+	startTime := time.Now()
+	n, err := pc.underlying.WriteTo(data, addr)
+	blockingTime := time.Since(startTime)
+
+	if err != nil {
+		err = pc.convertError(ctx, err, &pc.writeWatcher)
+	}
+
+	pc.counters.AddWrite(n, err, blockingTime)
+
+	if pc.statsGroup != nil {
+		pc.statsGroup.counters.AddWrite(n, err, blockingTime)
+	}
+
+	return n, err
+}
+
+func (pc *PacketConnection) LocalAddr() net.Addr {
+	return pc.underlying.LocalAddr()
+}
+
+func (pc *PacketConnection) Stats() Stats {
+	return pc.counters.Load()
+}
+
+func (pc *PacketConnection) IsClosed() bool {
+	return atomic.LoadInt32(&pc.isClosed) == 1
+}
+
+func (pc *PacketConnection) convertError(ctx context.Context, err error, watcher *watcher) error {
+	return convertError(ctx, err, nil, pc.IsClosed(), pc.derivesDeadlines, watcher)
+}
@@ -0,0 +1,90 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketConnection(t *testing.T) {
+	pc1 := newTestPacketConnection(t)
+	defer pc1.Close()
+	pc2 := newTestPacketConnection(t)
+	defer pc2.Close()
+	var sg StatsGroup
+	pc2.JoinStatsGroup(&sg)
+
+	if _, err := pc1.WriteTo(context.Background(), time.Time{}, []byte("ping"), pc2.LocalAddr()); err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var buffer [16]byte
+	n, addr, err := pc2.ReadFrom(context.Background(), time.Now().Add(time.Second), buffer[:])
+
+	if err != nil || string(buffer[:n]) != "ping" || addr.String() != pc1.LocalAddr().String() {
+		t.Fatalf("%#v %#v %#v", err, string(buffer[:n]), addr)
+	}
+
+	if _, _, err := pc2.ReadFrom(context.Background(), time.Now().Add(time.Second/20), buffer[:]); err == nil {
+		t.Error("no timeout")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if _, _, err := pc2.ReadFrom(ctx, time.Time{}, buffer[:]); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+
+	for _, s := range []Stats{pc2.Stats(), sg.Stats()} {
+		if s.NumberOfBytesRead != 4 || s.NumberOfReads != 3 || s.NumberOfTimeouts != 2 || s.NumberOfCancels != 0 {
+			t.Errorf("%#v", s)
+		}
+	}
+
+	if s := pc1.Stats(); s.NumberOfBytesWritten != 4 || s.NumberOfWrites != 1 {
+		t.Errorf("%#v", s)
+	}
+
+	pc2.Close()
+
+	if _, _, err := pc2.ReadFrom(context.Background(), time.Time{}, buffer[:]); err != ErrConnectionClosed {
+		t.Errorf("%#v", err)
+	}
+
+	if err := pc2.Close(); err != ErrConnectionClosed {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestPacketConnectionDeriveDeadlinesFromContext(t *testing.T) {
+	pc := newTestPacketConnection(t)
+	defer pc.Close()
+	pc.DeriveDeadlinesFromContext()
+	var buffer [16]byte
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if _, _, err := pc.ReadFrom(ctx, time.Now().Add(time.Second), buffer[:]); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+
+	if _, _, err := pc.ReadFrom(context.Background(), time.Now().Add(time.Second/20), buffer[:]); err != ErrTimedOut {
+		t.Errorf("%#v", err)
+	}
+
+	if s := pc.Stats(); s.NumberOfTimeouts != 2 || s.NumberOfCancels != 0 {
+		t.Errorf("%#v", s)
+	}
+}
+
+func newTestPacketConnection(t *testing.T) *PacketConnection {
+	underlying, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	return new(PacketConnection).Init(underlying)
+}
@@ -0,0 +1,355 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ProxyCommand uint8
+
+const (
+	ProxyCommandLocal ProxyCommand = iota
+	ProxyCommandProxy
+)
+
+const (
+	ProxyTLVTypeALPN      uint8 = 0x01
+	ProxyTLVTypeAuthority uint8 = 0x02
+	ProxyTLVTypeCRC32C    uint8 = 0x03
+	ProxyTLVTypeNoop      uint8 = 0x04
+	ProxyTLVTypeUniqueID  uint8 = 0x05
+	ProxyTLVTypeSSL       uint8 = 0x20
+	ProxyTLVTypeNetNS     uint8 = 0x30
+)
+
+type ProxyHeader struct {
+	Version         int
+	Command         ProxyCommand
+	SourceAddr      net.Addr
+	DestinationAddr net.Addr
+	TLVs            []ProxyTLV
+}
+
+func (ph *ProxyHeader) TLV(tlvType uint8) ([]byte, bool) {
+	for _, tlv := range ph.TLVs {
+		if tlv.Type == tlvType {
+			return tlv.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+type ProxyTLV struct {
+	Type  uint8
+	Value []byte
+}
+
+// ReadProxyHeader must be called before any other read on the connection.
+// The bytes read past the header, or all the bytes read if there is no header
+// (ErrNoProxyHeader), remain available to subsequent reads and to Upgrade.
+func (c *Connection) ReadProxyHeader(ctx context.Context, deadline time.Time) (ProxyHeader, error) {
+	pr := proxyHeaderReader{
+		connection: c,
+		ctx:        ctx,
+	}
+
+	c.PreRead(ctx, deadline)
+	proxyHeader, headerSize, err := pr.Read()
+
+	if headerSize < len(pr.buffer) {
+		c.pendingData = pr.buffer[headerSize:]
+	}
+
+	return proxyHeader, err
+}
+
+var (
+	ErrNoProxyHeader      = errors.New("toolkit/connection: no proxy header")
+	ErrInvalidProxyHeader = errors.New("toolkit/connection: invalid proxy header")
+)
+
+const (
+	proxyHeaderV1Signature = "PROXY "
+	proxyHeaderV2Signature = "\r\n\r\n\x00\r\nQUIT\n"
+	maxProxyHeaderV1Size   = 107
+	proxyHeaderV2MinSize   = 16
+
+	minProxyHeaderBufferSize = 128
+)
+
+type proxyHeaderReader struct {
+	connection *Connection
+	ctx        context.Context
+	buffer     []byte
+}
+
+func (pr *proxyHeaderReader) Read() (ProxyHeader, int, error) {
+	if err := pr.readMore(1); err != nil {
+		return ProxyHeader{}, 0, err
+	}
+
+	switch pr.buffer[0] {
+	case proxyHeaderV1Signature[0]:
+		if err := pr.matchSignature(proxyHeaderV1Signature); err != nil {
+			return ProxyHeader{}, 0, err
+		}
+
+		return pr.readV1()
+	case proxyHeaderV2Signature[0]:
+		if err := pr.matchSignature(proxyHeaderV2Signature); err != nil {
+			return ProxyHeader{}, 0, err
+		}
+
+		return pr.readV2()
+	default:
+		return ProxyHeader{}, 0, ErrNoProxyHeader
+	}
+}
+
+func (pr *proxyHeaderReader) matchSignature(signature string) error {
+	for i := 1; i < len(signature); i++ {
+		if i == len(pr.buffer) {
+			if err := pr.readMore(i + 1); err != nil {
+				return err
+			}
+		}
+
+		if pr.buffer[i] != signature[i] {
+			return ErrNoProxyHeader
+		}
+	}
+
+	return nil
+}
+
+func (pr *proxyHeaderReader) readV1() (ProxyHeader, int, error) {
+	for i := len(proxyHeaderV1Signature); ; i++ {
+		if i+1 >= maxProxyHeaderV1Size {
+			return ProxyHeader{}, 0, ErrInvalidProxyHeader
+		}
+
+		if i+1 >= len(pr.buffer) {
+			if err := pr.readMore(i + 2); err != nil {
+				return ProxyHeader{}, 0, err
+			}
+		}
+
+		if pr.buffer[i] == '\r' && pr.buffer[i+1] == '\n' {
+			proxyHeader, ok := parseProxyHeaderV1(string(pr.buffer[len(proxyHeaderV1Signature):i]))
+
+			if !ok {
+				return ProxyHeader{}, 0, ErrInvalidProxyHeader
+			}
+
+			return proxyHeader, i + 2, nil
+		}
+	}
+}
+
+func (pr *proxyHeaderReader) readV2() (ProxyHeader, int, error) {
+	if err := pr.readMore(proxyHeaderV2MinSize); err != nil {
+		return ProxyHeader{}, 0, err
+	}
+
+	headerSize := proxyHeaderV2MinSize + int(binary.BigEndian.Uint16(pr.buffer[14:]))
+
+	if err := pr.readMore(headerSize); err != nil {
+		return ProxyHeader{}, 0, err
+	}
+
+	proxyHeader, ok := parseProxyHeaderV2(pr.buffer[:headerSize])
+
+	if !ok {
+		return ProxyHeader{}, 0, ErrInvalidProxyHeader
+	}
+
+	return proxyHeader, headerSize, nil
+}
+
+func (pr *proxyHeaderReader) readMore(minDataSize int) error {
+	for len(pr.buffer) < minDataSize {
+		if cap(pr.buffer) < minDataSize {
+			bufferSize := 2 * cap(pr.buffer)
+
+			if bufferSize < minDataSize {
+				bufferSize = minDataSize
+			}
+
+			if bufferSize < minProxyHeaderBufferSize {
+				bufferSize = minProxyHeaderBufferSize
+			}
+
+			buffer := make([]byte, len(pr.buffer), bufferSize)
+			copy(buffer, pr.buffer)
+			pr.buffer = buffer
+		}
+
+		n, err := pr.connection.DoRead(pr.ctx, pr.buffer[len(pr.buffer):cap(pr.buffer)])
+		pr.buffer = pr.buffer[:len(pr.buffer)+n]
+
+		if err != nil {
+			if len(pr.buffer) >= minDataSize {
+				break
+			}
+
+			if err == io.EOF && len(pr.buffer) >= 1 {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return convertTimeoutError(err)
+		}
+	}
+
+	return nil
+}
+
+func parseProxyHeaderV1(line string) (ProxyHeader, bool) {
+	fields := strings.Split(line, " ")
+	proxyHeader := ProxyHeader{Version: 1}
+
+	if fields[0] == "UNKNOWN" {
+		proxyHeader.Command = ProxyCommandLocal
+		return proxyHeader, true
+	}
+
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return ProxyHeader{}, false
+	}
+
+	sourceAddr, ok := parseTCPAddrV1(fields[1], fields[3], fields[0] == "TCP4")
+
+	if !ok {
+		return ProxyHeader{}, false
+	}
+
+	destinationAddr, ok := parseTCPAddrV1(fields[2], fields[4], fields[0] == "TCP4")
+
+	if !ok {
+		return ProxyHeader{}, false
+	}
+
+	proxyHeader.Command = ProxyCommandProxy
+	proxyHeader.SourceAddr = sourceAddr
+	proxyHeader.DestinationAddr = destinationAddr
+	return proxyHeader, true
+}
+
+func parseTCPAddrV1(rawIP string, rawPort string, isIPv4 bool) (*net.TCPAddr, bool) {
+	ip := net.ParseIP(rawIP)
+
+	if ip == nil || (ip.To4() != nil) != isIPv4 {
+		return nil, false
+	}
+
+	port, err := strconv.ParseUint(rawPort, 10, 16)
+
+	if err != nil {
+		return nil, false
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, true
+}
+
+func parseProxyHeaderV2(header []byte) (ProxyHeader, bool) {
+	if header[12]>>4 != 2 {
+		return ProxyHeader{}, false
+	}
+
+	proxyHeader := ProxyHeader{Version: 2}
+
+	switch header[12] & 0xF {
+	case 0:
+		proxyHeader.Command = ProxyCommandLocal
+	case 1:
+		proxyHeader.Command = ProxyCommandProxy
+	default:
+		return ProxyHeader{}, false
+	}
+
+	family, protocol := header[13]>>4, header[13]&0xF
+	payload := header[proxyHeaderV2MinSize:]
+	var addrsSize int
+
+	switch family {
+	case 0:
+		addrsSize = 0
+	case 1:
+		addrsSize = 12
+	case 2:
+		addrsSize = 36
+	case 3:
+		addrsSize = 216
+	default:
+		return ProxyHeader{}, false
+	}
+
+	if protocol > 2 || len(payload) < addrsSize {
+		return ProxyHeader{}, false
+	}
+
+	if proxyHeader.Command == ProxyCommandProxy && family != 0 && protocol != 0 {
+		proxyHeader.SourceAddr, proxyHeader.DestinationAddr = parseAddrsV2(family, protocol, payload[:addrsSize])
+	}
+
+	for tlvs := payload[addrsSize:]; len(tlvs) >= 1; {
+		if len(tlvs) < 3 {
+			return ProxyHeader{}, false
+		}
+
+		valueSize := int(binary.BigEndian.Uint16(tlvs[1:]))
+
+		if len(tlvs) < 3+valueSize {
+			return ProxyHeader{}, false
+		}
+
+		proxyHeader.TLVs = append(proxyHeader.TLVs, ProxyTLV{
+			Type:  tlvs[0],
+			Value: append([]byte(nil), tlvs[3:3+valueSize]...),
+		})
+
+		tlvs = tlvs[3+valueSize:]
+	}
+
+	return proxyHeader, true
+}
+
+func parseAddrsV2(family uint8, protocol uint8, addrs []byte) (net.Addr, net.Addr) {
+	if family == 3 {
+		network := "unix"
+
+		if protocol == 2 {
+			network = "unixgram"
+		}
+
+		return &net.UnixAddr{Name: parseUnixPathV2(addrs[:108]), Net: network},
+			&net.UnixAddr{Name: parseUnixPathV2(addrs[108:]), Net: network}
+	}
+
+	ipSize := (len(addrs) - 4) / 2
+	sourceIP := net.IP(append([]byte(nil), addrs[:ipSize]...))
+	destinationIP := net.IP(append([]byte(nil), addrs[ipSize:2*ipSize]...))
+	sourcePort := int(binary.BigEndian.Uint16(addrs[2*ipSize:]))
+	destinationPort := int(binary.BigEndian.Uint16(addrs[2*ipSize+2:]))
+
+	if protocol == 2 {
+		return &net.UDPAddr{IP: sourceIP, Port: sourcePort}, &net.UDPAddr{IP: destinationIP, Port: destinationPort}
+	}
+
+	return &net.TCPAddr{IP: sourceIP, Port: sourcePort}, &net.TCPAddr{IP: destinationIP, Port: destinationPort}
+}
+
+func parseUnixPathV2(rawPath []byte) string {
+	if i := bytes.IndexByte(rawPath, 0); i >= 0 {
+		rawPath = rawPath[:i]
+	}
+
+	return string(rawPath)
+}
@@ -0,0 +1,177 @@
+package connection
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	server := proxyHeaderTestServer(t, []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\nGET / HTTP/1.1\r\n"))
+	defer server.Close()
+	proxyHeader, err := server.ReadProxyHeader(context.Background(), time.Time{})
+
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if proxyHeader.Version != 1 || proxyHeader.Command != ProxyCommandProxy {
+		t.Errorf("%#v", proxyHeader)
+	}
+
+	if addr := proxyHeader.SourceAddr.String(); addr != "192.168.0.1:56324" {
+		t.Errorf("%#v", addr)
+	}
+
+	if addr := proxyHeader.DestinationAddr.String(); addr != "192.168.0.11:443" {
+		t.Errorf("%#v", addr)
+	}
+
+	checkProxyHeaderLeftover(t, server, "GET / HTTP/1.1\r\n", 63)
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	header := []byte(proxyHeaderV2Signature)
+	header = append(header, 0x21, 0x11, 0, 0)
+	header = append(header, 10, 0, 0, 1, 10, 0, 0, 2, 0x1F, 0x90, 0x01, 0xBB)
+	header = append(header, ProxyTLVTypeAuthority, 0, 11)
+	header = append(header, "example.com"...)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(header)-proxyHeaderV2MinSize))
+	server := proxyHeaderTestServer(t, append(header, "hello"...))
+	defer server.Close()
+	proxyHeader, err := server.ReadProxyHeader(context.Background(), time.Time{})
+
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if proxyHeader.Version != 2 || proxyHeader.Command != ProxyCommandProxy {
+		t.Errorf("%#v", proxyHeader)
+	}
+
+	if addr, ok := proxyHeader.SourceAddr.(*net.TCPAddr); !ok || addr.String() != "10.0.0.1:8080" {
+		t.Errorf("%#v", proxyHeader.SourceAddr)
+	}
+
+	if addr, ok := proxyHeader.DestinationAddr.(*net.TCPAddr); !ok || addr.String() != "10.0.0.2:443" {
+		t.Errorf("%#v", proxyHeader.DestinationAddr)
+	}
+
+	if value, ok := proxyHeader.TLV(ProxyTLVTypeAuthority); !ok || string(value) != "example.com" {
+		t.Errorf("%#v", value)
+	}
+
+	checkProxyHeaderLeftover(t, server, "hello", len(header)+5)
+}
+
+func TestReadProxyHeaderErrors(t *testing.T) {
+	server := proxyHeaderTestServer(t, []byte("PRI * HTTP/2.0\r\n"))
+	defer server.Close()
+
+	if _, err := server.ReadProxyHeader(context.Background(), time.Time{}); err != ErrNoProxyHeader {
+		t.Errorf("%#v", err)
+	}
+
+	checkProxyHeaderLeftover(t, server, "PRI * HTTP/2.0\r\n", 16)
+	server = proxyHeaderTestServer(t, []byte("PROXY TCP4 bad address\r\n"))
+	defer server.Close()
+
+	if _, err := server.ReadProxyHeader(context.Background(), time.Time{}); err != ErrInvalidProxyHeader {
+		t.Errorf("%#v", err)
+	}
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	server = new(Connection).Init(c2)
+	defer server.Close()
+	go c1.Write([]byte("PRO"))
+
+	if _, err := server.ReadProxyHeader(context.Background(), time.Now().Add(time.Second/10)); err != ErrTimedOut {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestUpgradeAfterProxyHeader(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	server := new(Connection).Init(c2)
+	defer server.Close()
+	serverConfig, clientConfig := makeTLSConfigs(t)
+	errs := make(chan error, 1)
+
+	go func() {
+		tlsConn := tls.Client(&proxyHeaderTestConn{c1, []byte("PROXY UNKNOWN\r\n")}, clientConfig)
+		_, err := tlsConn.Write([]byte("hello"))
+		errs <- err
+	}()
+
+	if _, err := server.ReadProxyHeader(context.Background(), time.Time{}); err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if len(server.pendingData) == 0 {
+		t.Fatal("no pending data")
+	}
+
+	if err := server.Upgrade(context.Background(), serverConfig, false); err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	var buffer [5]byte
+
+	if _, err := server.ReadFull(context.Background(), time.Time{}, buffer[:]); err != nil || string(buffer[:]) != "hello" {
+		t.Errorf("%#v %#v", err, string(buffer[:]))
+	}
+
+	if err := <-errs; err != nil {
+		t.Errorf("%#v", err)
+	}
+}
+
+type proxyHeaderTestConn struct {
+	net.Conn
+
+	header []byte
+}
+
+func (phtc *proxyHeaderTestConn) Write(data []byte) (int, error) {
+	if phtc.header == nil {
+		return phtc.Conn.Write(data)
+	}
+
+	header := phtc.header
+	phtc.header = nil
+
+	if _, err := phtc.Conn.Write(append(header, data...)); err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func proxyHeaderTestServer(t *testing.T, data []byte) *Connection {
+	c1, c2 := net.Pipe()
+
+	go func() {
+		c1.Write(data)
+		c1.Close()
+	}()
+
+	return new(Connection).Init(c2)
+}
+
+func checkProxyHeaderLeftover(t *testing.T, server *Connection, leftover string, dataSize int) {
+	buffer := make([]byte, len(leftover)+1)
+
+	if n, err := server.ReadFull(context.Background(), time.Time{}, buffer); n != len(leftover) || err != io.ErrUnexpectedEOF || string(buffer[:n]) != leftover {
+		t.Errorf("%#v %#v", err, string(buffer[:n]))
+	}
+
+	if n := server.Stats().NumberOfBytesRead; n != int64(dataSize) {
+		t.Errorf("%#v", n)
+	}
+}
@@ -0,0 +1,247 @@
+package connection
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/let-z-go/toolkit/timerpool"
+)
+
+type RateLimiter struct {
+	lock       sync.Mutex
+	rate       float64
+	burst      float64
+	tokens     float64
+	updateTime time.Time
+}
+
+func (rl *RateLimiter) Init(rate int64, burst int64) *RateLimiter {
+	rl.updateTime = time.Now()
+	rl.doSetLimit(rate, burst)
+	rl.tokens = rl.burst
+	return rl
+}
+
+func (rl *RateLimiter) SetLimit(rate int64, burst int64) {
+	rl.lock.Lock()
+	rl.refill(time.Now())
+	rl.doSetLimit(rate, burst)
+
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+
+	rl.lock.Unlock()
+}
+
+func (rl *RateLimiter) Limit() (int64, int64) {
+	rl.lock.Lock()
+	rate, burst := rl.rate, rl.burst
+	rl.lock.Unlock()
+	return int64(rate), int64(burst)
+}
+
+func (rl *RateLimiter) Wait(ctx context.Context, deadline time.Time, maxNumberOfTokens int) (int, error) {
+	for {
+		numberOfTokens, waitTime := rl.take(maxNumberOfTokens)
+
+		if numberOfTokens >= 1 {
+			return numberOfTokens, nil
+		}
+
+		if err := sleep(ctx, deadline, waitTime); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (rl *RateLimiter) Release(numberOfTokens int) {
+	if numberOfTokens < 1 {
+		return
+	}
+
+	rl.lock.Lock()
+	rl.tokens += float64(numberOfTokens)
+
+	if rl.tokens > rl.burst {
+		rl.tokens = rl.burst
+	}
+
+	rl.lock.Unlock()
+}
+
+// waitForDebt waits until the tokens charged beyond the available ones have
+// been paid off.
+func (rl *RateLimiter) waitForDebt(ctx context.Context, deadline time.Time) error {
+	for {
+		waitTime := rl.getDebtTime()
+
+		if waitTime == 0 {
+			return nil
+		}
+
+		if err := sleep(ctx, deadline, waitTime); err != nil {
+			return err
+		}
+	}
+}
+
+// charge takes the given number of tokens, running into debt if there are not
+// enough.
+func (rl *RateLimiter) charge(numberOfTokens int) {
+	if numberOfTokens < 1 {
+		return
+	}
+
+	rl.lock.Lock()
+
+	if rl.rate != 0 {
+		rl.refill(time.Now())
+		rl.tokens -= float64(numberOfTokens)
+	}
+
+	rl.lock.Unlock()
+}
+
+func (rl *RateLimiter) doSetLimit(rate int64, burst int64) {
+	if rate < 1 {
+		rate = 0
+	}
+
+	if burst < 1 {
+		burst = rate
+	}
+
+	rl.rate = float64(rate)
+	rl.burst = float64(burst)
+}
+
+func (rl *RateLimiter) take(maxNumberOfTokens int) (int, time.Duration) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if rl.rate == 0 {
+		return maxNumberOfTokens, 0
+	}
+
+	rl.refill(time.Now())
+
+	if rl.tokens < 1 {
+		return 0, time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+	}
+
+	numberOfTokens := maxNumberOfTokens
+
+	if float64(numberOfTokens) > rl.tokens {
+		numberOfTokens = int(rl.tokens)
+	}
+
+	rl.tokens -= float64(numberOfTokens)
+	return numberOfTokens, 0
+}
+
+func (rl *RateLimiter) getDebtTime() time.Duration {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	if rl.rate == 0 {
+		return 0
+	}
+
+	rl.refill(time.Now())
+
+	if rl.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - rl.tokens) / rl.rate * float64(time.Second))
+}
+
+func (rl *RateLimiter) refill(now time.Time) {
+	if elapsedTime := now.Sub(rl.updateTime); elapsedTime >= 1 {
+		rl.tokens += rl.rate * elapsedTime.Seconds()
+
+		if rl.tokens > rl.burst {
+			rl.tokens = rl.burst
+		}
+	}
+
+	rl.updateTime = now
+}
+
+// SetReadRateLimiters and SetWriteRateLimiters must be called before the
+// connection is used. A rate limiter may be shared by many connections. Reads
+// are charged after the fact for the data actually read, so a blocked read
+// holds no tokens; the next read waits until the debt has been paid off.
+func (c *Connection) SetReadRateLimiters(rateLimiters ...*RateLimiter) {
+	c.readRateLimiters = rateLimiters
+}
+
+func (c *Connection) SetWriteRateLimiters(rateLimiters ...*RateLimiter) {
+	c.writeRateLimiters = rateLimiters
+}
+
+func waitForRateLimiters(ctx context.Context, deadline time.Time, rateLimiters []*RateLimiter, maxNumberOfTokens int) (int, error) {
+	numberOfTokens := maxNumberOfTokens
+
+	for i, rateLimiter := range rateLimiters {
+		numberOfTokens2, err := rateLimiter.Wait(ctx, deadline, numberOfTokens)
+
+		if err != nil {
+			releaseRateLimiters(rateLimiters[:i], numberOfTokens)
+			return 0, err
+		}
+
+		releaseRateLimiters(rateLimiters[:i], numberOfTokens-numberOfTokens2)
+		numberOfTokens = numberOfTokens2
+	}
+
+	return numberOfTokens, nil
+}
+
+func waitForRateLimiterDebts(ctx context.Context, deadline time.Time, rateLimiters []*RateLimiter) error {
+	for _, rateLimiter := range rateLimiters {
+		if err := rateLimiter.waitForDebt(ctx, deadline); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func chargeRateLimiters(rateLimiters []*RateLimiter, numberOfTokens int) {
+	for _, rateLimiter := range rateLimiters {
+		rateLimiter.charge(numberOfTokens)
+	}
+}
+
+func releaseRateLimiters(rateLimiters []*RateLimiter, numberOfTokens int) {
+	for _, rateLimiter := range rateLimiters {
+		rateLimiter.Release(numberOfTokens)
+	}
+}
+
+func sleep(ctx context.Context, deadline time.Time, duration time.Duration) error {
+	if !deadline.IsZero() {
+		if timeout := time.Until(deadline); timeout < duration {
+			duration = timeout
+		}
+
+		if duration <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+	}
+
+	timer := timerpool.GetTimer(duration)
+
+	select {
+	case <-timer.C:
+		timerpool.PutTimer(timer)
+		return nil
+	case <-ctx.Done():
+		timerpool.StopAndPutTimer(timer)
+		return ctx.Err()
+	}
+}
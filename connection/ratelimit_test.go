@@ -0,0 +1,102 @@
+package connection
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	rl := new(RateLimiter).Init(10, 100)
+
+	if n, err := rl.Wait(context.Background(), time.Time{}, 150); n != 100 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if n, err := rl.Wait(context.Background(), time.Now().Add(time.Millisecond/10), 1); n != 0 || err != os.ErrDeadlineExceeded {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if n, err := rl.Wait(ctx, time.Time{}, 1); n != 0 || err != context.Canceled {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	rl.SetLimit(0, 0)
+
+	if n, err := rl.Wait(context.Background(), time.Time{}, 150); n != 150 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+}
+
+func TestWriteRateLimit(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	rl1 := new(RateLimiter).Init(1000, 100)
+	rl2 := new(RateLimiter).Init(2000, 50)
+	c.SetWriteRateLimiters(rl1, rl2)
+	go io.Copy(io.Discard, c2)
+	startTime := time.Now()
+
+	if n, err := c.Write(context.Background(), time.Time{}, make([]byte, 250)); n != 250 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if elapsedTime := time.Since(startTime); elapsedTime < time.Second/10 || elapsedTime > time.Second/4 {
+		t.Errorf("%#v", elapsedTime)
+	}
+}
+
+func TestReadRateLimit(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	rl := new(RateLimiter).Init(10, 10)
+	c.SetReadRateLimiters(rl)
+	go c2.Write(make([]byte, 100))
+	var buffer [100]byte
+
+	if n, err := c.Read(context.Background(), time.Time{}, buffer[:]); n != 100 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if n, err := c.Read(context.Background(), time.Now().Add(time.Second/50), buffer[:]); n != 0 || err != os.ErrDeadlineExceeded {
+		t.Errorf("%#v %#v", n, err)
+	}
+}
+
+func TestReadRateLimitIdleReader(t *testing.T) {
+	rl := new(RateLimiter).Init(1000, 1000)
+	var cs [2]*Connection
+	var peers [2]net.Conn
+
+	for i := range cs {
+		c1, c2 := net.Pipe()
+		defer c2.Close()
+		cs[i] = new(Connection).Init(c1)
+		defer cs[i].Close()
+		cs[i].SetReadRateLimiters(rl)
+		peers[i] = c2
+	}
+
+	go func() {
+		var buffer [1000]byte
+		cs[0].Read(context.Background(), time.Time{}, buffer[:])
+	}()
+
+	time.Sleep(time.Second / 20)
+	go peers[1].Write(make([]byte, 100))
+	var buffer [1000]byte
+
+	if n, err := cs[1].Read(context.Background(), time.Now().Add(time.Second/20), buffer[:]); n != 100 || err != nil {
+		t.Errorf("%#v %#v", n, err)
+	}
+}
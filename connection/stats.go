@@ -0,0 +1,147 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Stats counts expired context deadlines as timeouts and only cancelled
+// contexts as cancels.
+type Stats struct {
+	NumberOfBytesRead    int64
+	NumberOfBytesWritten int64
+	NumberOfReads        int64
+	NumberOfWrites       int64
+	NumberOfTimeouts     int64
+	NumberOfCancels      int64
+	ReadBlockingTime     time.Duration
+	WriteBlockingTime    time.Duration
+}
+
+type Observer struct {
+	OnRead      func(connection *Connection, dataSize int, err error)
+	OnWrite     func(connection *Connection, dataSize int, err error)
+	OnClose     func(connection *Connection, err error)
+	OnReadData  func(connection *Connection, data []byte)
+	OnWriteData func(connection *Connection, data []byte)
+}
+
+type StatsGroup struct {
+	counters counters
+}
+
+func (sg *StatsGroup) Stats() Stats {
+	return sg.counters.Load()
+}
+
+func (c *Connection) Stats() Stats {
+	return c.counters.Load()
+}
+
+// SetObserver and JoinStatsGroup must be called before the connection is used.
+func (c *Connection) SetObserver(observer Observer) {
+	c.observer = observer
+}
+
+func (c *Connection) JoinStatsGroup(statsGroup *StatsGroup) {
+	c.statsGroup = statsGroup
+}
+
+func (c *Connection) onRead(dataSize int, err error, blockingTime time.Duration) {
+	c.counters.AddRead(dataSize, err, blockingTime)
+
+	if c.statsGroup != nil {
+		c.statsGroup.counters.AddRead(dataSize, err, blockingTime)
+	}
+
+	if c.observer.OnRead != nil {
+		c.observer.OnRead(c, dataSize, err)
+	}
+}
+
+func (c *Connection) onWrite(dataSize int, err error, blockingTime time.Duration) {
+	c.counters.AddWrite(dataSize, err, blockingTime)
+
+	if c.statsGroup != nil {
+		c.statsGroup.counters.AddWrite(dataSize, err, blockingTime)
+	}
+
+	if c.observer.OnWrite != nil {
+		c.observer.OnWrite(c, dataSize, err)
+	}
+}
+
+func (c *Connection) onReadData(data []byte) {
+	if c.observer.OnReadData != nil && len(data) >= 1 {
+		c.observer.OnReadData(c, data)
+	}
+}
+
+func (c *Connection) onWriteData(data []byte) {
+	if c.observer.OnWriteData != nil && len(data) >= 1 {
+		c.observer.OnWriteData(c, data)
+	}
+}
+
+func (c *Connection) onClose(err error) {
+	if c.observer.OnClose != nil {
+		c.observer.OnClose(c, err)
+	}
+}
+
+type counters struct {
+	numberOfBytesRead    int64
+	numberOfBytesWritten int64
+	numberOfReads        int64
+	numberOfWrites       int64
+	numberOfTimeouts     int64
+	numberOfCancels      int64
+	readBlockingTime     int64
+	writeBlockingTime    int64
+}
+
+func (cs *counters) AddRead(dataSize int, err error, blockingTime time.Duration) {
+	atomic.AddInt64(&cs.numberOfBytesRead, int64(dataSize))
+	atomic.AddInt64(&cs.numberOfReads, 1)
+	atomic.AddInt64(&cs.readBlockingTime, int64(blockingTime))
+	cs.addError(err)
+}
+
+func (cs *counters) AddWrite(dataSize int, err error, blockingTime time.Duration) {
+	atomic.AddInt64(&cs.numberOfBytesWritten, int64(dataSize))
+	atomic.AddInt64(&cs.numberOfWrites, 1)
+	atomic.AddInt64(&cs.writeBlockingTime, int64(blockingTime))
+	cs.addError(err)
+}
+
+func (cs *counters) Load() Stats {
+	return Stats{
+		NumberOfBytesRead:    atomic.LoadInt64(&cs.numberOfBytesRead),
+		NumberOfBytesWritten: atomic.LoadInt64(&cs.numberOfBytesWritten),
+		NumberOfReads:        atomic.LoadInt64(&cs.numberOfReads),
+		NumberOfWrites:       atomic.LoadInt64(&cs.numberOfWrites),
+		NumberOfTimeouts:     atomic.LoadInt64(&cs.numberOfTimeouts),
+		NumberOfCancels:      atomic.LoadInt64(&cs.numberOfCancels),
+		ReadBlockingTime:     time.Duration(atomic.LoadInt64(&cs.readBlockingTime)),
+		WriteBlockingTime:    time.Duration(atomic.LoadInt64(&cs.writeBlockingTime)),
+	}
+}
+
+func (cs *counters) addError(err error) {
+	if err == nil {
+		return
+	}
+
+	switch err {
+	case context.Canceled:
+		atomic.AddInt64(&cs.numberOfCancels, 1)
+	case context.DeadlineExceeded:
+		atomic.AddInt64(&cs.numberOfTimeouts, 1)
+	default:
+		if err, ok := err.(net.Error); ok && err.Timeout() {
+			atomic.AddInt64(&cs.numberOfTimeouts, 1)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package connection
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	c1, c2 := net.Pipe()
+	var sg StatsGroup
+	c := new(Connection).Init(c1)
+	c.JoinStatsGroup(&sg)
+	var onReadDataSizes, onWriteDataSizes []int
+	var onCloseErr error = net.ErrClosed
+
+	c.SetObserver(Observer{
+		OnRead: func(_ *Connection, dataSize int, _ error) {
+			onReadDataSizes = append(onReadDataSizes, dataSize)
+		},
+		OnWrite: func(_ *Connection, dataSize int, _ error) {
+			onWriteDataSizes = append(onWriteDataSizes, dataSize)
+		},
+		OnClose: func(_ *Connection, err error) {
+			onCloseErr = err
+		},
+	})
+
+	go func() {
+		var buffer [3]byte
+		c2.Read(buffer[:])
+		c2.Write([]byte("hello"))
+	}()
+
+	c.Write(context.Background(), time.Time{}, []byte("abc"))
+	var buffer [5]byte
+	c.Read(context.Background(), time.Time{}, buffer[:])
+	c.Read(context.Background(), time.Now().Add(time.Second/20), buffer[:])
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Write(ctx, time.Time{}, []byte("abc"))
+	c.Close()
+	c2.Close()
+
+	for _, s := range []Stats{c.Stats(), sg.Stats()} {
+		if s.NumberOfBytesRead != 5 || s.NumberOfBytesWritten != 3 || s.NumberOfReads != 2 || s.NumberOfWrites != 2 || s.NumberOfTimeouts != 1 || s.NumberOfCancels != 1 {
+			t.Errorf("%#v", s)
+		}
+
+		if s.ReadBlockingTime < time.Second/20 {
+			t.Errorf("%#v", s.ReadBlockingTime)
+		}
+	}
+
+	if len(onReadDataSizes) != 2 || onReadDataSizes[0] != 5 || onReadDataSizes[1] != 0 {
+		t.Errorf("%#v", onReadDataSizes)
+	}
+
+	if len(onWriteDataSizes) != 2 || onWriteDataSizes[0] != 3 || onWriteDataSizes[1] != 0 {
+		t.Errorf("%#v", onWriteDataSizes)
+	}
+
+	if onCloseErr != nil {
+		t.Errorf("%#v", onCloseErr)
+	}
+}
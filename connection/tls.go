@@ -0,0 +1,79 @@
+package connection
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// Upgrade must not run concurrently with any other operation on the connection.
+func (c *Connection) Upgrade(ctx context.Context, config *tls.Config, isClient bool) error {
+	if c.IsClosed() {
+		return ErrConnectionClosed
+	}
+
+	if _, ok := c.underlying.(*tls.Conn); ok {
+		return ErrAlreadyUpgraded
+	}
+
+	underlying := c.underlying
+
+	if len(c.pendingData) >= 1 {
+		underlying = &prefixedConn{underlying, c.pendingData}
+		c.pendingData = nil
+	}
+
+	var tlsConn *tls.Conn
+
+	if isClient {
+		tlsConn = tls.Client(underlying, config)
+	} else {
+		tlsConn = tls.Server(underlying, config)
+	}
+
+	c.PreRead(ctx, time.Time{})
+	c.PreWrite(ctx, time.Time{})
+
+	defer func() {
+		c.PreRead(context.Background(), time.Time{})
+		c.PreWrite(context.Background(), time.Time{})
+	}()
+
+	if err := tlsConn.Handshake(); err != nil {
+		return c.convertError(ctx, err, &c.readWatcher)
+	}
+
+	c.underlying = tlsConn
+	return nil
+}
+
+func (c *Connection) TLSConnectionState() (tls.ConnectionState, bool) {
+	tlsConn, ok := c.underlying.(*tls.Conn)
+
+	if !ok {
+		return tls.ConnectionState{}, false
+	}
+
+	return tlsConn.ConnectionState(), true
+}
+
+var ErrAlreadyUpgraded = errors.New("toolkit/connection: already upgraded")
+
+// prefixedConn hands the data left by ReadProxyHeader over to the TLS layer.
+type prefixedConn struct {
+	net.Conn
+
+	prefix []byte
+}
+
+func (pc *prefixedConn) Read(buffer []byte) (int, error) {
+	if len(pc.prefix) == 0 {
+		return pc.Conn.Read(buffer)
+	}
+
+	n := copy(buffer, pc.prefix)
+	pc.prefix = pc.prefix[n:]
+	return n, nil
+}
@@ -0,0 +1,146 @@
+package connection
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestUpgrade(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := new(Connection).Init(c1)
+	defer client.Close()
+	server := new(Connection).Init(c2)
+	defer server.Close()
+	defer c1.Close()
+	defer c2.Close()
+	serverConfig, clientConfig := makeTLSConfigs(t)
+	errs := make(chan error, 1)
+
+	go func() {
+		var buffer [5]byte
+		server.ReadFull(context.Background(), time.Time{}, buffer[:])
+
+		if string(buffer[:]) != "HELLO" {
+			errs <- nil
+			return
+		}
+
+		if err := server.Upgrade(context.Background(), serverConfig, false); err != nil {
+			errs <- err
+			return
+		}
+
+		server.ReadFull(context.Background(), time.Time{}, buffer[:])
+		_, err := server.Write(context.Background(), time.Time{}, buffer[:])
+		errs <- err
+	}()
+
+	client.Write(context.Background(), time.Time{}, []byte("HELLO"))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if err := client.Upgrade(ctx, clientConfig, true); err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	cancel()
+
+	if err := client.Upgrade(context.Background(), clientConfig, true); err != ErrAlreadyUpgraded {
+		t.Errorf("%#v", err)
+	}
+
+	if state, ok := client.TLSConnectionState(); !ok || !state.HandshakeComplete {
+		t.Errorf("%#v", state)
+	}
+
+	if _, err := client.DoWrite(context.Background(), []byte("world")); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	var buffer [5]byte
+
+	if n, err := client.ReadFull(context.Background(), time.Time{}, buffer[:]); n != 5 || err != nil || string(buffer[:]) != "world" {
+		t.Errorf("%#v %#v", n, err)
+	}
+
+	if err := <-errs; err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if _, err := client.Read(ctx, time.Time{}, buffer[:]); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestUpgradeCancellation(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := new(Connection).Init(c1)
+	defer c.Close()
+	_, clientConfig := makeTLSConfigs(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if err := c.Upgrade(ctx, clientConfig, true); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+
+	if _, ok := c.TLSConnectionState(); ok {
+		t.Error("connection upgraded")
+	}
+}
+
+func makeTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	certificateData, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := x509.ParseCertificate(certificateData)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificatePool := x509.NewCertPool()
+	certificatePool.AddCert(certificate)
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{certificateData}, PrivateKey: key}},
+	}
+
+	clientConfig := &tls.Config{
+		RootCAs:    certificatePool,
+		ServerName: "localhost",
+	}
+
+	return serverConfig, clientConfig
+}
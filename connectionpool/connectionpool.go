@@ -0,0 +1,316 @@
+package connectionpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/let-z-go/intrusive"
+
+	"github.com/let-z-go/toolkit/connection"
+	"github.com/let-z-go/toolkit/deque"
+	"github.com/let-z-go/toolkit/semaphore"
+)
+
+// Liveness checking is opt-in, as it delays every reuse of an idle connection:
+// either set CheckLiveness, or set LivenessCheckTimeout to use the default
+// check, which expects a read with that timeout to time out.
+type Options struct {
+	MaxNumberOfIdleConnections   int
+	MaxNumberOfActiveConnections int
+	IdleTimeout                  time.Duration
+	LivenessCheckTimeout         time.Duration
+	Dial                         func(ctx context.Context, address string) (*connection.Connection, error)
+	CheckLiveness                func(ctx context.Context, connection *connection.Connection) bool
+}
+
+func (o *Options) normalize() {
+	if o.MaxNumberOfIdleConnections < 0 {
+		o.MaxNumberOfIdleConnections = 0
+	} else if o.MaxNumberOfIdleConnections == 0 {
+		o.MaxNumberOfIdleConnections = defaultMaxNumberOfIdleConnections
+	}
+
+	if o.MaxNumberOfActiveConnections < 1 {
+		o.MaxNumberOfActiveConnections = maxInt
+	}
+
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = defaultIdleTimeout
+	}
+
+	if o.Dial == nil {
+		dialer := new(connection.Dialer).Init(connection.DialerOptions{})
+
+		o.Dial = func(ctx context.Context, address string) (*connection.Connection, error) {
+			return dialer.Dial(ctx, "tcp", address)
+		}
+	}
+
+	if o.CheckLiveness == nil && o.LivenessCheckTimeout >= 1 {
+		livenessCheckTimeout := o.LivenessCheckTimeout
+
+		o.CheckLiveness = func(ctx context.Context, connection *connection.Connection) bool {
+			var buffer [1]byte
+			n, err := connection.Read(ctx, time.Now().Add(livenessCheckTimeout), buffer[:])
+			connection.PreRead(context.Background(), time.Time{})
+
+			if n >= 1 {
+				return false
+			}
+
+			err2, ok := err.(interface{ Timeout() bool })
+			return ok && err2.Timeout()
+		}
+	}
+}
+
+type Pool struct {
+	options  Options
+	lock     sync.Mutex
+	buckets  map[string]*bucket
+	isClosed int32
+}
+
+func (p *Pool) Init(options Options) *Pool {
+	options.normalize()
+	p.options = options
+	p.buckets = map[string]*bucket{}
+	return p
+}
+
+func (p *Pool) Close() error {
+	if !atomic.CompareAndSwapInt32(&p.isClosed, 0, 1) {
+		return ErrPoolClosed
+	}
+
+	p.lock.Lock()
+	buckets := p.buckets
+	p.buckets = map[string]*bucket{}
+	p.lock.Unlock()
+
+	for _, bucket := range buckets {
+		bucket.Close()
+	}
+
+	return nil
+}
+
+func (p *Pool) Get(ctx context.Context, address string) (*PooledConnection, error) {
+	bucket, err := p.getBucket(address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bucket.ActiveConnections.Up(ctx, false, nil); err != nil {
+		p.releaseBucket(bucket)
+		return nil, convertSemaphoreError(err)
+	}
+
+	for {
+		listNode, err := bucket.IdleConnections.RemoveTail(cancelledCtx, false)
+
+		if err != nil {
+			break
+		}
+
+		idleConnection := (*idleConnection)(listNode.GetContainer(unsafe.Offsetof(idleConnection{}.ListNode)))
+
+		if time.Since(idleConnection.IdleSince) >= p.options.IdleTimeout || (p.options.CheckLiveness != nil && !p.options.CheckLiveness(ctx, idleConnection.Connection)) {
+			idleConnection.Connection.Close()
+			continue
+		}
+
+		return &PooledConnection{
+			pool:       p,
+			bucket:     bucket,
+			connection: idleConnection.Connection,
+		}, nil
+	}
+
+	connection, err := p.options.Dial(ctx, address)
+
+	if err != nil {
+		bucket.ActiveConnections.Down(context.Background(), false, nil)
+		p.releaseBucket(bucket)
+		return nil, err
+	}
+
+	return &PooledConnection{
+		pool:       p,
+		bucket:     bucket,
+		connection: connection,
+	}, nil
+}
+
+func (p *Pool) IsClosed() bool {
+	return atomic.LoadInt32(&p.isClosed) == 1
+}
+
+func (p *Pool) Stats(address string) (int, int) {
+	p.lock.Lock()
+	bucket, ok := p.buckets[address]
+	p.lock.Unlock()
+
+	if !ok {
+		return 0, 0
+	}
+
+	return bucket.ActiveConnections.Value(), bucket.IdleConnections.Length()
+}
+
+func (p *Pool) getBucket(address string) (*bucket, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.IsClosed() {
+		return nil, ErrPoolClosed
+	}
+
+	b, ok := p.buckets[address]
+
+	if !ok {
+		b = new(bucket).Init(address, p.options.MaxNumberOfActiveConnections, p.options.MaxNumberOfIdleConnections)
+		p.buckets[address] = b
+	}
+
+	b.NumberOfReferences++
+	return b, nil
+}
+
+// releaseBucket removes the bucket once it is neither referenced nor holds
+// idle connections, so that buckets do not pile up for addresses no longer
+// used.
+func (p *Pool) releaseBucket(b *bucket) {
+	p.lock.Lock()
+	b.NumberOfReferences--
+
+	if b.NumberOfReferences == 0 && b.IdleConnections.Length() == 0 && p.buckets[b.Address] == b {
+		delete(p.buckets, b.Address)
+	}
+
+	p.lock.Unlock()
+}
+
+func (p *Pool) release(pooledConnection *PooledConnection, discard bool) {
+	bucket := pooledConnection.bucket
+
+	if discard || p.IsClosed() {
+		pooledConnection.connection.Close()
+	} else {
+		idleConnection := idleConnection{
+			Connection: pooledConnection.connection,
+			IdleSince:  time.Now(),
+		}
+
+		if bucket.IdleConnections.AppendNode(cancelledCtx, &idleConnection.ListNode) != nil {
+			pooledConnection.connection.Close()
+		}
+	}
+
+	bucket.ActiveConnections.Down(context.Background(), false, nil)
+	bucket.PruneIdleConnections(p.options.IdleTimeout)
+	p.releaseBucket(bucket)
+}
+
+type PooledConnection struct {
+	pool       *Pool
+	bucket     *bucket
+	connection *connection.Connection
+	isReleased int32
+}
+
+func (pc *PooledConnection) Connection() *connection.Connection {
+	return pc.connection
+}
+
+func (pc *PooledConnection) Release() {
+	if atomic.CompareAndSwapInt32(&pc.isReleased, 0, 1) {
+		pc.pool.release(pc, false)
+	}
+}
+
+func (pc *PooledConnection) Discard() {
+	if atomic.CompareAndSwapInt32(&pc.isReleased, 0, 1) {
+		pc.pool.release(pc, true)
+	}
+}
+
+var ErrPoolClosed = errors.New("toolkit/connectionpool: pool closed")
+
+const (
+	defaultMaxNumberOfIdleConnections = 2
+	defaultIdleTimeout                = 90 * time.Second
+)
+
+const maxInt = int(^uint(0) >> 1)
+
+type bucket struct {
+	Address            string
+	ActiveConnections  semaphore.Semaphore
+	IdleConnections    deque.Deque
+	NumberOfReferences int
+}
+
+func (b *bucket) Init(address string, maxNumberOfActiveConnections int, maxNumberOfIdleConnections int) *bucket {
+	b.Address = address
+	b.ActiveConnections.Init(0, maxNumberOfActiveConnections, 0)
+	b.IdleConnections.Init(maxNumberOfIdleConnections)
+	return b
+}
+
+func (b *bucket) Close() {
+	b.ActiveConnections.Close(nil)
+	list := deque.NewList()
+	b.IdleConnections.Close(list)
+	getListNode := list.Underlying.GetNodesSafely()
+
+	for listNode := getListNode(); listNode != nil; listNode = getListNode() {
+		idleConnection := (*idleConnection)(listNode.GetContainer(unsafe.Offsetof(idleConnection{}.ListNode)))
+		idleConnection.Connection.Close()
+	}
+}
+
+func (b *bucket) PruneIdleConnections(idleTimeout time.Duration) {
+	for {
+		listNode, err := b.IdleConnections.RemoveHead(cancelledCtx, true)
+
+		if err != nil {
+			return
+		}
+
+		idleConnection := (*idleConnection)(listNode.GetContainer(unsafe.Offsetof(idleConnection{}.ListNode)))
+
+		if time.Since(idleConnection.IdleSince) < idleTimeout {
+			b.IdleConnections.DiscardNodeRemoval(listNode, true)
+			return
+		}
+
+		b.IdleConnections.CommitNodeRemoval()
+		idleConnection.Connection.Close()
+	}
+}
+
+type idleConnection struct {
+	ListNode   intrusive.ListNode
+	Connection *connection.Connection
+	IdleSince  time.Time
+}
+
+func convertSemaphoreError(err error) error {
+	if err == semaphore.ErrSemaphoreClosed {
+		return ErrPoolClosed
+	}
+
+	return err
+}
+
+var cancelledCtx = func() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return ctx
+}()
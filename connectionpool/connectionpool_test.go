@@ -0,0 +1,154 @@
+package connectionpool
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	address, numberOfAccepts := startServer(t)
+	p := new(Pool).Init(Options{MaxNumberOfIdleConnections: 1, MaxNumberOfActiveConnections: 2})
+	defer p.Close()
+	pc1, err := p.Get(context.Background(), address)
+
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	pc2, err := p.Get(context.Background(), address)
+
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second/20)
+	defer cancel()
+
+	if _, err := p.Get(ctx, address); err != context.DeadlineExceeded {
+		t.Errorf("%#v", err)
+	}
+
+	go func() {
+		time.Sleep(time.Second / 20)
+		pc1.Release()
+	}()
+
+	pc3, err := p.Get(context.Background(), address)
+
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	if pc3.Connection() != pc1.Connection() {
+		t.Error("connection not reused")
+	}
+
+	pc2.Release()
+	pc3.Release()
+
+	if numberOfActiveConnections, numberOfIdleConnections := p.Stats(address); numberOfActiveConnections != 0 || numberOfIdleConnections != 1 {
+		t.Errorf("%#v %#v", numberOfActiveConnections, numberOfIdleConnections)
+	}
+
+	if n := atomic.LoadInt32(numberOfAccepts); n != 2 {
+		t.Errorf("%#v", n)
+	}
+
+	p.Close()
+
+	if _, err := p.Get(context.Background(), address); err != ErrPoolClosed {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestPoolLivenessAndExpiry(t *testing.T) {
+	address, numberOfAccepts := startServer(t)
+	p := new(Pool).Init(Options{IdleTimeout: time.Second / 10, LivenessCheckTimeout: time.Millisecond})
+	defer p.Close()
+	pc1, _ := p.Get(context.Background(), address)
+	pc1.Release()
+	pc2, _ := p.Get(context.Background(), address)
+
+	if pc2.Connection() != pc1.Connection() {
+		t.Error("connection not reused")
+	}
+
+	pc2.Release()
+	time.Sleep(time.Second / 5)
+	pc3, _ := p.Get(context.Background(), address)
+
+	if pc3.Connection() == pc1.Connection() {
+		t.Error("expired connection reused")
+	}
+
+	pc3.Connection().Write(context.Background(), time.Time{}, []byte("close"))
+	time.Sleep(time.Second / 20)
+	pc3.Release()
+	pc4, _ := p.Get(context.Background(), address)
+
+	if pc4.Connection() == pc3.Connection() {
+		t.Error("dead connection reused")
+	}
+
+	pc4.Discard()
+	time.Sleep(time.Second / 20)
+
+	if n := atomic.LoadInt32(numberOfAccepts); n != 3 {
+		t.Errorf("%#v", n)
+	}
+}
+
+func TestPoolPruneBuckets(t *testing.T) {
+	address, _ := startServer(t)
+	p := new(Pool).Init(Options{MaxNumberOfIdleConnections: -1})
+	defer p.Close()
+	pc, err := p.Get(context.Background(), address)
+
+	if err != nil {
+		t.Fatalf("%#v", err)
+	}
+
+	pc.Release()
+
+	if _, err := p.Get(context.Background(), "127.0.0.1:0"); err == nil {
+		t.Error("no error")
+	}
+
+	if n := len(p.buckets); n != 0 {
+		t.Errorf("%#v", n)
+	}
+}
+
+func startServer(t *testing.T) (string, *int32) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+	numberOfAccepts := new(int32)
+
+	go func() {
+		for {
+			c, err := l.Accept()
+
+			if err != nil {
+				return
+			}
+
+			atomic.AddInt32(numberOfAccepts, 1)
+
+			go func() {
+				var buffer [5]byte
+				c.Read(buffer[:])
+				c.Close()
+			}()
+		}
+	}()
+
+	return l.Addr().String(), numberOfAccepts
+}
@@ -0,0 +1,279 @@
+package framing
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"time"
+
+	"github.com/let-z-go/toolkit/bytestream"
+	"github.com/let-z-go/toolkit/connection"
+	"github.com/let-z-go/toolkit/utils"
+)
+
+type PrefixType int
+
+const (
+	PrefixUint8 PrefixType = 1 + iota
+	PrefixUint16
+	PrefixUint32
+	PrefixUint64
+	PrefixUvarint
+)
+
+type Codec struct {
+	PrefixType   PrefixType
+	ByteOrder    binary.ByteOrder
+	MaxFrameSize int
+}
+
+// Init limits frames to 16 MiB if maxFrameSize is not positive.
+func (c *Codec) Init(prefixType PrefixType, byteOrder binary.ByteOrder, maxFrameSize int) *Codec {
+	utils.Assert(prefixType.isValid(), func() string {
+		return fmt.Sprintf("toolkit/framing: invalid argument: prefixType=%#v", prefixType)
+	})
+
+	utils.Assert(byteOrder != nil || prefixType == PrefixUint8 || prefixType == PrefixUvarint, func() string {
+		return "toolkit/framing: invalid argument: byteOrder is nil"
+	})
+
+	if maxFrameSize <= 0 {
+		maxFrameSize = defaultMaxFrameSize
+	}
+
+	if maxPayloadSize := prefixType.maxPayloadSize(); maxFrameSize > maxPayloadSize {
+		maxFrameSize = maxPayloadSize
+	}
+
+	c.PrefixType = prefixType
+	c.ByteOrder = byteOrder
+	c.MaxFrameSize = maxFrameSize
+	return c
+}
+
+func (c *Codec) decodePrefix(data []byte) (int, int, error) {
+	var payloadSize uint64
+	var prefixSize int
+
+	switch c.PrefixType {
+	case PrefixUint8:
+		if len(data) < 1 {
+			return 0, 0, nil
+		}
+
+		payloadSize, prefixSize = uint64(data[0]), 1
+	case PrefixUint16:
+		if len(data) < 2 {
+			return 0, 0, nil
+		}
+
+		payloadSize, prefixSize = uint64(c.ByteOrder.Uint16(data)), 2
+	case PrefixUint32:
+		if len(data) < 4 {
+			return 0, 0, nil
+		}
+
+		payloadSize, prefixSize = uint64(c.ByteOrder.Uint32(data)), 4
+	case PrefixUint64:
+		if len(data) < 8 {
+			return 0, 0, nil
+		}
+
+		payloadSize, prefixSize = c.ByteOrder.Uint64(data), 8
+	case PrefixUvarint:
+		payloadSize, prefixSize = binary.Uvarint(data)
+
+		if prefixSize == 0 {
+			return 0, 0, nil
+		}
+
+		if prefixSize < 0 {
+			return 0, 0, ErrFrameTooLarge
+		}
+	default:
+		return 0, 0, ErrInvalidPrefixType
+	}
+
+	if payloadSize > uint64(c.MaxFrameSize) || payloadSize > uint64(maxInt-prefixSize) {
+		return 0, 0, ErrFrameTooLarge
+	}
+
+	return prefixSize, int(payloadSize), nil
+}
+
+func (c *Codec) encodePrefix(buffer []byte, payloadSize int) int {
+	switch c.PrefixType {
+	case PrefixUint8:
+		buffer[0] = uint8(payloadSize)
+		return 1
+	case PrefixUint16:
+		c.ByteOrder.PutUint16(buffer, uint16(payloadSize))
+		return 2
+	case PrefixUint32:
+		c.ByteOrder.PutUint32(buffer, uint32(payloadSize))
+		return 4
+	case PrefixUint64:
+		c.ByteOrder.PutUint64(buffer, uint64(payloadSize))
+		return 8
+	case PrefixUvarint:
+		return binary.PutUvarint(buffer, uint64(payloadSize))
+	default:
+		return 0
+	}
+}
+
+type FrameReader struct {
+	connection    *connection.Connection
+	codec         Codec
+	stream        bytestream.ByteStream
+	lastFrameSize int
+}
+
+func (fr *FrameReader) Init(connection *connection.Connection, codec Codec) *FrameReader {
+	fr.connection = connection
+	fr.codec = codec
+	return fr
+}
+
+// ReadFrame returns the payload of the next frame. The payload refers to the
+// internal buffer and is only valid until the next call to ReadFrame.
+func (fr *FrameReader) ReadFrame(ctx context.Context, deadline time.Time) ([]byte, error) {
+	fr.stream.Skip(fr.lastFrameSize)
+	fr.lastFrameSize = 0
+	readIsPrepared := false
+
+	for {
+		data := fr.stream.GetData()
+		prefixSize, payloadSize, err := fr.codec.decodePrefix(data)
+
+		if err != nil {
+			return nil, err
+		}
+
+		bufferSize := minReadBufferSize
+
+		if prefixSize >= 1 {
+			if frameSize := prefixSize + payloadSize; len(data) >= frameSize {
+				fr.lastFrameSize = frameSize
+				return data[prefixSize:frameSize], nil
+			} else if frameSize-len(data) > bufferSize {
+				bufferSize = frameSize - len(data)
+			}
+		}
+
+		if !readIsPrepared {
+			fr.connection.PreRead(ctx, deadline)
+			readIsPrepared = true
+		}
+
+		fr.stream.ReserveBuffer(bufferSize)
+		n, err := fr.connection.DoRead(ctx, fr.stream.GetBuffer())
+		fr.stream.CommitBuffer(n)
+
+		if err != nil {
+			if n >= 1 {
+				continue
+			}
+
+			if err == io.EOF && fr.stream.GetDataSize() >= 1 {
+				err = ErrFrameTruncated
+			}
+
+			return nil, err
+		}
+	}
+}
+
+type FrameWriter struct {
+	connection *connection.Connection
+	codec      Codec
+	stream     bytestream.ByteStream
+}
+
+func (fw *FrameWriter) Init(connection *connection.Connection, codec Codec) *FrameWriter {
+	fw.connection = connection
+	fw.codec = codec
+	return fw
+}
+
+// WriteFrame lets the callback fill the payload of a frame of the given size
+// in place and then writes the whole frame.
+func (fw *FrameWriter) WriteFrame(ctx context.Context, deadline time.Time, payloadSize int, callback func([]byte) error) error {
+	if !fw.codec.PrefixType.isValid() {
+		return ErrInvalidPrefixType
+	}
+
+	if payloadSize < 0 || payloadSize > fw.codec.MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	var prefix [binary.MaxVarintLen64]byte
+	prefixSize := fw.codec.encodePrefix(prefix[:], payloadSize)
+	fw.stream.Write(prefix[:prefixSize])
+
+	if err := fw.stream.WriteDirectly(payloadSize, func(buffer []byte) error {
+		return callback(buffer[:payloadSize])
+	}); err != nil {
+		fw.stream.Unwrite(prefixSize)
+		return err
+	}
+
+	_, err := fw.connection.WriteAll(ctx, deadline, fw.stream.GetData())
+	fw.stream.Skip(fw.stream.GetDataSize())
+	return err
+}
+
+// WriteFrameBytes writes the prefix and the payload with a single vectored
+// write, without copying the payload.
+func (fw *FrameWriter) WriteFrameBytes(ctx context.Context, deadline time.Time, payload []byte) error {
+	if !fw.codec.PrefixType.isValid() {
+		return ErrInvalidPrefixType
+	}
+
+	if len(payload) > fw.codec.MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	var prefix [binary.MaxVarintLen64]byte
+	prefixSize := fw.codec.encodePrefix(prefix[:], len(payload))
+	_, err := fw.connection.WriteBuffers(ctx, deadline, net.Buffers{prefix[:prefixSize], payload})
+	return err
+}
+
+var (
+	ErrFrameTooLarge     = errors.New("toolkit/framing: frame too large")
+	ErrFrameTruncated    = errors.New("toolkit/framing: frame truncated")
+	ErrInvalidPrefixType = errors.New("toolkit/framing: invalid prefix type")
+)
+
+const (
+	defaultMaxFrameSize = 16 * 1024 * 1024
+	minReadBufferSize   = 4096
+)
+
+const maxInt = int(^uint(0) >> 1)
+
+func (pt PrefixType) isValid() bool {
+	return pt >= PrefixUint8 && pt <= PrefixUvarint
+}
+
+func (pt PrefixType) maxPayloadSize() int {
+	switch pt {
+	case PrefixUint8:
+		return math.MaxUint8
+	case PrefixUint16:
+		return math.MaxUint16
+	case PrefixUint32:
+		if maxUint32 := uint64(math.MaxUint32); maxUint32 < uint64(maxInt) {
+			return int(maxUint32)
+		}
+
+		return maxInt
+	default:
+		return maxInt
+	}
+}
@@ -0,0 +1,124 @@
+package framing
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/let-z-go/toolkit/connection"
+)
+
+func TestReadWriteFrame(t *testing.T) {
+	for _, prefixType := range []PrefixType{PrefixUint8, PrefixUint16, PrefixUint32, PrefixUint64, PrefixUvarint} {
+		for _, byteOrder := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+			c1, c2 := net.Pipe()
+			codec := *new(Codec).Init(prefixType, byteOrder, 200)
+			fr := new(FrameReader).Init(new(connection.Connection).Init(c1), codec)
+			fw := new(FrameWriter).Init(new(connection.Connection).Init(c2), codec)
+			payloads := [][]byte{[]byte("hello"), {}, bytes.Repeat([]byte("x"), 200)}
+
+			go func() {
+				for _, payload := range payloads {
+					if err := fw.WriteFrameBytes(context.Background(), time.Time{}, payload); err != nil {
+						t.Errorf("%#v", err)
+					}
+				}
+
+				if err := fw.WriteFrameBytes(context.Background(), time.Time{}, make([]byte, 201)); err != ErrFrameTooLarge {
+					t.Errorf("%#v", err)
+				}
+
+				c2.Close()
+			}()
+
+			for _, payload := range payloads {
+				if frame, err := fr.ReadFrame(context.Background(), time.Time{}); !bytes.Equal(frame, payload) || err != nil {
+					t.Errorf("%#v %#v %#v", prefixType, frame, err)
+				}
+			}
+
+			if frame, err := fr.ReadFrame(context.Background(), time.Time{}); frame != nil || err != io.EOF {
+				t.Errorf("%#v %#v", frame, err)
+			}
+
+			c1.Close()
+		}
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	c1, c2 := net.Pipe()
+	codec := *new(Codec).Init(PrefixUint16, binary.BigEndian, 10)
+	fr := new(FrameReader).Init(new(connection.Connection).Init(c1), codec)
+
+	go func() {
+		c2.Write([]byte{0, 3, 'a', 'b'})
+		c2.Close()
+	}()
+
+	if frame, err := fr.ReadFrame(context.Background(), time.Time{}); frame != nil || err != ErrFrameTruncated {
+		t.Errorf("%#v %#v", frame, err)
+	}
+
+	c1, c2 = net.Pipe()
+	fr = new(FrameReader).Init(new(connection.Connection).Init(c1), codec)
+
+	go func() {
+		c2.Write([]byte{0, 11})
+	}()
+
+	if frame, err := fr.ReadFrame(context.Background(), time.Time{}); frame != nil || err != ErrFrameTooLarge {
+		t.Errorf("%#v %#v", frame, err)
+	}
+
+	c1.Close()
+	c2.Close()
+}
+
+func TestReadFrameHugePrefix(t *testing.T) {
+	for _, maxFrameSize := range []int{0, maxInt} {
+		c1, c2 := net.Pipe()
+		codec := *new(Codec).Init(PrefixUint64, binary.BigEndian, maxFrameSize)
+		fr := new(FrameReader).Init(new(connection.Connection).Init(c1), codec)
+
+		go func() {
+			c2.Write([]byte{0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+		}()
+
+		if frame, err := fr.ReadFrame(context.Background(), time.Time{}); frame != nil || err != ErrFrameTooLarge {
+			t.Errorf("%#v %#v", frame, err)
+		}
+
+		c1.Close()
+		c2.Close()
+	}
+
+	if codec := new(Codec).Init(PrefixUint64, binary.BigEndian, 0); codec.MaxFrameSize != defaultMaxFrameSize {
+		t.Errorf("%#v", codec.MaxFrameSize)
+	}
+}
+
+func TestInvalidPrefixType(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	var codec Codec
+	fr := new(FrameReader).Init(new(connection.Connection).Init(c1), codec)
+	fw := new(FrameWriter).Init(new(connection.Connection).Init(c2), codec)
+
+	if err := fw.WriteFrameBytes(context.Background(), time.Time{}, nil); err != ErrInvalidPrefixType {
+		t.Errorf("%#v", err)
+	}
+
+	if err := fw.WriteFrame(context.Background(), time.Time{}, 0, func([]byte) error { return nil }); err != ErrInvalidPrefixType {
+		t.Errorf("%#v", err)
+	}
+
+	if frame, err := fr.ReadFrame(context.Background(), time.Time{}); frame != nil || err != ErrInvalidPrefixType {
+		t.Errorf("%#v %#v", frame, err)
+	}
+}
@@ -0,0 +1,555 @@
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/let-z-go/toolkit/condition"
+	"github.com/let-z-go/toolkit/connection"
+	"github.com/let-z-go/toolkit/framing"
+)
+
+type SessionOptions struct {
+	MaxFrameSize      int
+	InitialWindowSize int
+	AcceptBacklog     int
+	KeepaliveInterval time.Duration
+	KeepaliveTimeout  time.Duration
+	WriteTimeout      time.Duration
+}
+
+func (so *SessionOptions) normalize() {
+	if so.MaxFrameSize < 1 {
+		so.MaxFrameSize = defaultMaxFrameSize
+	} else if so.MaxFrameSize > maxMaxFrameSize {
+		so.MaxFrameSize = maxMaxFrameSize
+	}
+
+	if so.InitialWindowSize < defaultInitialWindowSize {
+		so.InitialWindowSize = defaultInitialWindowSize
+	} else if so.InitialWindowSize > maxWindowSize {
+		so.InitialWindowSize = maxWindowSize
+	}
+
+	if so.AcceptBacklog < 1 {
+		so.AcceptBacklog = defaultAcceptBacklog
+	}
+
+	if so.KeepaliveInterval == 0 {
+		so.KeepaliveInterval = defaultKeepaliveInterval
+	}
+
+	if so.KeepaliveTimeout <= 0 {
+		so.KeepaliveTimeout = so.KeepaliveInterval
+	}
+
+	if so.WriteTimeout <= 0 {
+		so.WriteTimeout = defaultWriteTimeout
+	}
+}
+
+// Session carries streams over a connection. Both ends must agree on which
+// one is the client. A negative KeepaliveInterval disables keepalive, otherwise
+// the connection must not have been used before.
+type Session struct {
+	connection       *connection.Connection
+	options          SessionOptions
+	frameReader      framing.FrameReader
+	frameWriter      framing.FrameWriter
+	lock             sync.Mutex
+	streams          map[uint32]*Stream
+	noStreams        condition.Condition
+	nextStreamID     uint32
+	lastPeerStreamID uint32
+	goAwayIsSent     bool
+	goAwayIsReceived bool
+	err              error
+	acceptQueue      chan *Stream
+	closed           chan struct{}
+	queueLock        sync.Mutex
+	pendingFrames    []*outgoingFrame
+	queueErr         error
+	hasPendingFrames chan struct{}
+}
+
+func (s *Session) Init(connection *connection.Connection, isClient bool, options SessionOptions) *Session {
+	options.normalize()
+	s.connection = connection
+	s.options = options
+	codec := new(framing.Codec).Init(framing.PrefixUint32, binary.BigEndian, frameHeaderSize+maxMaxFrameSize)
+	s.frameReader.Init(connection, *codec)
+	s.frameWriter.Init(connection, *codec)
+	s.streams = map[uint32]*Stream{}
+	s.noStreams.Init(&s.lock)
+
+	if isClient {
+		s.nextStreamID = 1
+	} else {
+		s.nextStreamID = 2
+	}
+
+	s.acceptQueue = make(chan *Stream, options.AcceptBacklog)
+	s.closed = make(chan struct{})
+	s.hasPendingFrames = make(chan struct{}, 1)
+
+	if options.KeepaliveInterval >= 1 {
+		s.startKeepalive()
+	}
+
+	go s.readFrames()
+	go s.writeFrames()
+	return s
+}
+
+func (s *Session) Close() error {
+	if !s.close(ErrSessionClosed) {
+		return ErrSessionClosed
+	}
+
+	return nil
+}
+
+// Shutdown sends GOAWAY, after which neither end opens new streams, waits for
+// the existing streams to finish and then closes the session.
+func (s *Session) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+
+	if s.err != nil {
+		s.lock.Unlock()
+		return ErrSessionClosed
+	}
+
+	if !s.goAwayIsSent {
+		s.goAwayIsSent = true
+		body := make([]byte, 4)
+		binary.BigEndian.PutUint32(body, s.lastPeerStreamID)
+		s.enqueueFrame(&outgoingFrame{
+			Type: frameGoAway,
+			Body: body,
+		})
+	}
+
+	for len(s.streams) >= 1 {
+		if _, err := s.noStreams.WaitFor(ctx); err != nil {
+			s.lock.Unlock()
+			return err
+		}
+	}
+
+	s.lock.Unlock()
+
+	if err := s.flush(ctx); err != nil {
+		return err
+	}
+
+	return s.Close()
+}
+
+func (s *Session) OpenStream() (*Stream, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.err != nil {
+		return nil, ErrSessionClosed
+	}
+
+	if s.goAwayIsSent || s.goAwayIsReceived {
+		return nil, ErrSessionGoingAway
+	}
+
+	if s.nextStreamID > maxStreamID {
+		return nil, ErrStreamIDsExhausted
+	}
+
+	stream := new(Stream).init(s, s.nextStreamID)
+	s.nextStreamID += 2
+	s.streams[stream.id] = stream
+	s.enqueueFrame(&outgoingFrame{
+		Type:     frameData,
+		Flags:    flagSYN,
+		StreamID: stream.id,
+	})
+
+	s.announceWindowSize(stream.id)
+	return stream, nil
+}
+
+func (s *Session) AcceptStream(ctx context.Context) (*Stream, error) {
+	select {
+	case stream := <-s.acceptQueue:
+		return stream, nil
+	case <-s.closed:
+		return nil, ErrSessionClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Session) NumberOfStreams() int {
+	s.lock.Lock()
+	numberOfStreams := len(s.streams)
+	s.lock.Unlock()
+	return numberOfStreams
+}
+
+func (s *Session) IsClosed() bool {
+	select {
+	case <-s.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Session) startKeepalive() {
+	s.connection.StartKeepalive(connection.KeepaliveOptions{
+		HeartbeatInterval: s.options.KeepaliveInterval,
+		HeartbeatTimeout:  s.options.KeepaliveTimeout,
+		Heartbeat: func(context.Context, *connection.Connection) error {
+			return s.enqueueFrame(&outgoingFrame{
+				Type: framePing,
+				Body: make([]byte, 8),
+			})
+		},
+	})
+}
+
+func (s *Session) close(err error) bool {
+	s.lock.Lock()
+
+	if s.err != nil {
+		s.lock.Unlock()
+		return false
+	}
+
+	s.err = err
+	streams := s.streams
+	s.streams = nil
+	s.noStreams.Broadcast()
+	close(s.closed)
+	s.lock.Unlock()
+	s.queueLock.Lock()
+	pendingFrames := s.pendingFrames
+	s.pendingFrames = nil
+	s.queueErr = ErrSessionClosed
+	s.queueLock.Unlock()
+
+	for _, frame := range pendingFrames {
+		if frame.Done != nil {
+			frame.Done <- ErrSessionClosed
+		}
+	}
+
+	for _, stream := range streams {
+		stream.abort(ErrSessionClosed)
+	}
+
+	s.connection.Close()
+	return true
+}
+
+func (s *Session) readFrames() {
+	for {
+		payload, err := s.frameReader.ReadFrame(context.Background(), time.Time{})
+
+		if err == nil {
+			err = s.handleFrame(payload)
+		}
+
+		if err != nil {
+			s.close(err)
+			return
+		}
+	}
+}
+
+func (s *Session) handleFrame(payload []byte) error {
+	if len(payload) < frameHeaderSize {
+		return ErrProtocolError
+	}
+
+	flags, streamID := payload[1], binary.BigEndian.Uint32(payload[2:])
+	body := payload[frameHeaderSize:]
+
+	switch frameType(payload[0]) {
+	case frameData:
+		return s.handleData(flags, streamID, body)
+	case frameWindowUpdate:
+		return s.handleWindowUpdate(streamID, body)
+	case framePing:
+		if flags&flagACK == 0 {
+			return s.enqueueFrame(&outgoingFrame{
+				Type:  framePing,
+				Flags: flagACK,
+				Body:  append([]byte(nil), body...),
+			})
+		}
+
+		return nil
+	case frameGoAway:
+		return s.handleGoAway(body)
+	default:
+		return ErrProtocolError
+	}
+}
+
+func (s *Session) handleData(flags uint8, streamID uint32, data []byte) error {
+	s.lock.Lock()
+
+	if s.err != nil {
+		s.lock.Unlock()
+		return ErrSessionClosed
+	}
+
+	stream, ok := s.streams[streamID]
+
+	if flags&flagSYN != 0 {
+		if ok || streamID%2 == s.nextStreamID%2 || streamID <= s.lastPeerStreamID {
+			s.lock.Unlock()
+			return ErrProtocolError
+		}
+
+		s.lastPeerStreamID = streamID
+
+		if s.goAwayIsSent {
+			s.lock.Unlock()
+			return s.resetStream(streamID)
+		}
+
+		stream = new(Stream).init(s, streamID)
+
+		select {
+		case s.acceptQueue <- stream:
+		default:
+			s.lock.Unlock()
+			return s.resetStream(streamID)
+		}
+
+		s.streams[streamID] = stream
+		s.announceWindowSize(streamID)
+	} else if !ok {
+		s.lock.Unlock()
+		return nil
+	}
+
+	s.lock.Unlock()
+	return stream.onData(flags, data)
+}
+
+func (s *Session) handleWindowUpdate(streamID uint32, body []byte) error {
+	if len(body) != 4 {
+		return ErrProtocolError
+	}
+
+	s.lock.Lock()
+	stream, ok := s.streams[streamID]
+	s.lock.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return stream.onWindowUpdate(int(binary.BigEndian.Uint32(body)))
+}
+
+func (s *Session) handleGoAway(body []byte) error {
+	if len(body) != 4 {
+		return ErrProtocolError
+	}
+
+	lastStreamID := binary.BigEndian.Uint32(body)
+	var refusedStreams []*Stream
+	s.lock.Lock()
+	s.goAwayIsReceived = true
+
+	for streamID, stream := range s.streams {
+		if streamID%2 == s.nextStreamID%2 && streamID > lastStreamID {
+			delete(s.streams, streamID)
+			refusedStreams = append(refusedStreams, stream)
+		}
+	}
+
+	if len(s.streams) == 0 {
+		s.noStreams.Broadcast()
+	}
+
+	s.lock.Unlock()
+
+	for _, stream := range refusedStreams {
+		stream.abort(ErrStreamRefused)
+	}
+
+	return nil
+}
+
+func (s *Session) resetStream(streamID uint32) error {
+	return s.enqueueFrame(&outgoingFrame{
+		Type:     frameData,
+		Flags:    flagRST,
+		StreamID: streamID,
+	})
+}
+
+func (s *Session) announceWindowSize(streamID uint32) {
+	if increment := s.options.InitialWindowSize - defaultInitialWindowSize; increment >= 1 {
+		s.updateWindow(streamID, increment)
+	}
+}
+
+func (s *Session) updateWindow(streamID uint32, increment int) error {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, uint32(increment))
+	return s.enqueueFrame(&outgoingFrame{
+		Type:     frameWindowUpdate,
+		StreamID: streamID,
+		Body:     body,
+	})
+}
+
+func (s *Session) removeStream(streamID uint32) {
+	s.lock.Lock()
+
+	if _, ok := s.streams[streamID]; ok {
+		delete(s.streams, streamID)
+
+		if len(s.streams) == 0 {
+			s.noStreams.Broadcast()
+		}
+	}
+
+	s.lock.Unlock()
+}
+
+func (s *Session) flush(ctx context.Context) error {
+	done := make(chan error, 1)
+
+	if err := s.enqueueFrame(&outgoingFrame{
+		Type: frameFlushMarker,
+		Done: done,
+	}); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Session) enqueueFrame(frame *outgoingFrame) error {
+	s.queueLock.Lock()
+
+	if s.queueErr != nil {
+		s.queueLock.Unlock()
+		return s.queueErr
+	}
+
+	s.pendingFrames = append(s.pendingFrames, frame)
+	s.queueLock.Unlock()
+
+	select {
+	case s.hasPendingFrames <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (s *Session) writeFrames() {
+	for {
+		select {
+		case <-s.hasPendingFrames:
+		case <-s.closed:
+			return
+		}
+
+		s.queueLock.Lock()
+		pendingFrames := s.pendingFrames
+		s.pendingFrames = nil
+		s.queueLock.Unlock()
+
+		for i, frame := range pendingFrames {
+			var err error
+
+			if frame.Type != frameFlushMarker {
+				err = s.writeFrame(frame)
+			}
+
+			if frame.Done != nil {
+				frame.Done <- err
+			}
+
+			if err != nil {
+				for _, frame := range pendingFrames[i+1:] {
+					if frame.Done != nil {
+						frame.Done <- ErrSessionClosed
+					}
+				}
+
+				s.close(err)
+				return
+			}
+		}
+	}
+}
+
+func (s *Session) writeFrame(frame *outgoingFrame) error {
+	return s.frameWriter.WriteFrame(context.Background(), time.Now().Add(s.options.WriteTimeout), frameHeaderSize+len(frame.Body), func(buffer []byte) error {
+		buffer[0] = uint8(frame.Type)
+		buffer[1] = frame.Flags
+		binary.BigEndian.PutUint32(buffer[2:], frame.StreamID)
+		copy(buffer[frameHeaderSize:], frame.Body)
+		return nil
+	})
+}
+
+var (
+	ErrSessionClosed      = errors.New("toolkit/mux: session closed")
+	ErrSessionGoingAway   = errors.New("toolkit/mux: session going away")
+	ErrStreamIDsExhausted = errors.New("toolkit/mux: stream ids exhausted")
+	ErrProtocolError      = errors.New("toolkit/mux: protocol error")
+)
+
+const (
+	defaultMaxFrameSize      = 16 * 1024
+	maxMaxFrameSize          = 16 * 1024 * 1024
+	defaultInitialWindowSize = 256 * 1024
+	maxWindowSize            = 1<<31 - 1
+	defaultAcceptBacklog     = 256
+	defaultKeepaliveInterval = 30 * time.Second
+	defaultWriteTimeout      = 10 * time.Second
+	maxStreamID              = 1<<31 - 1
+)
+
+type frameType uint8
+
+const (
+	frameData frameType = iota
+	frameWindowUpdate
+	framePing
+	frameGoAway
+
+	frameFlushMarker frameType = 0xFF
+)
+
+const (
+	flagSYN uint8 = 1 << iota
+	flagFIN
+	flagRST
+	flagACK
+)
+
+const frameHeaderSize = 6
+
+type outgoingFrame struct {
+	Type     frameType
+	Flags    uint8
+	StreamID uint32
+	Body     []byte
+	Done     chan error
+}
@@ -0,0 +1,229 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/let-z-go/toolkit/connection"
+	"github.com/let-z-go/toolkit/connection/connectiontest"
+)
+
+func TestStreams(t *testing.T) {
+	client, server := newTestSessions(SessionOptions{}, SessionOptions{})
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		for {
+			stream, err := server.AcceptStream(context.Background())
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				data, _ := readAll(stream)
+				stream.Write(context.Background(), time.Time{}, bytes.ToUpper(data))
+				stream.Close(context.Background())
+			}()
+		}
+	}()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			stream, err := client.OpenStream()
+
+			if err != nil {
+				t.Errorf("%#v", err)
+				return
+			}
+
+			message := fmt.Sprintf("hello %d", i)
+			stream.Write(context.Background(), time.Time{}, []byte(message))
+
+			if err := stream.Close(context.Background()); err != nil {
+				t.Errorf("%#v", err)
+			}
+
+			if data, err := readAll(stream); err != nil || string(data) != fmt.Sprintf("HELLO %d", i) {
+				t.Errorf("%#v %#v", err, string(data))
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	time.Sleep(time.Second / 10)
+
+	if n := client.NumberOfStreams(); n != 0 {
+		t.Errorf("%#v", n)
+	}
+
+	if n := server.NumberOfStreams(); n != 0 {
+		t.Errorf("%#v", n)
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	client, server := newTestSessions(SessionOptions{}, SessionOptions{})
+	defer client.Close()
+	defer server.Close()
+	stream1, _ := client.OpenStream()
+	data := make([]byte, 2*defaultInitialWindowSize)
+	n, err := stream1.Write(context.Background(), time.Now().Add(time.Second/5), data)
+
+	if n != defaultInitialWindowSize || err != connection.ErrTimedOut {
+		t.Fatalf("%#v %#v", n, err)
+	}
+
+	stream2, _ := server.AcceptStream(context.Background())
+	buffer := make([]byte, len(data))
+
+	go func() {
+		if n, err := stream1.Write(context.Background(), time.Time{}, data[n:]); n != defaultInitialWindowSize || err != nil {
+			t.Errorf("%#v %#v", n, err)
+		}
+	}()
+
+	if _, err := readFull(stream2, buffer); err != nil {
+		t.Errorf("%#v", err)
+	}
+
+	stream1.Reset()
+
+	if _, err := stream1.Write(context.Background(), time.Time{}, data); err != ErrStreamClosed {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := stream2.Read(context.Background(), time.Time{}, buffer); err != ErrStreamReset {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	client, server := newTestSessions(SessionOptions{}, SessionOptions{})
+	defer client.Close()
+	defer server.Close()
+	stream1, _ := client.OpenStream()
+	stream1.Write(context.Background(), time.Time{}, []byte("x"))
+	stream2, _ := server.AcceptStream(context.Background())
+	errs := make(chan error, 1)
+
+	go func() {
+		errs <- server.Shutdown(context.Background())
+	}()
+
+	time.Sleep(time.Second / 10)
+
+	if _, err := client.OpenStream(); err != ErrSessionGoingAway {
+		t.Errorf("%#v", err)
+	}
+
+	if _, err := server.OpenStream(); err != ErrSessionGoingAway {
+		t.Errorf("%#v", err)
+	}
+
+	stream2.Write(context.Background(), time.Time{}, []byte("y"))
+	stream2.Close(context.Background())
+	stream1.Close(context.Background())
+
+	if data, err := readAll(stream1); err != nil || string(data) != "y" {
+		t.Errorf("%#v %#v", err, string(data))
+	}
+
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Errorf("%#v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdown timed out")
+	}
+
+	time.Sleep(time.Second / 10)
+
+	if !client.IsClosed() {
+		t.Error("client not closed")
+	}
+
+	if _, err := client.OpenStream(); err != ErrSessionClosed {
+		t.Errorf("%#v", err)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	options := SessionOptions{
+		KeepaliveInterval: time.Second / 20,
+		KeepaliveTimeout:  time.Second / 20,
+	}
+
+	client, server := newTestSessions(options, options)
+	defer server.Close()
+	time.Sleep(time.Second / 2)
+
+	if client.IsClosed() || server.IsClosed() {
+		t.Fatal("session closed")
+	}
+
+	c1, c2 := connectiontest.NewPair(connectiontest.Options{}, connectiontest.Options{})
+	defer c2.Close()
+	session := new(Session).Init(c1, true, options)
+	time.Sleep(time.Second / 2)
+
+	if !session.IsClosed() {
+		t.Error("session not closed")
+	}
+
+	if _, err := session.OpenStream(); err != ErrSessionClosed {
+		t.Errorf("%#v", err)
+	}
+
+	client.Close()
+}
+
+func newTestSessions(clientOptions SessionOptions, serverOptions SessionOptions) (*Session, *Session) {
+	c1, c2 := connectiontest.NewPair(connectiontest.Options{}, connectiontest.Options{})
+	return new(Session).Init(c1, true, clientOptions), new(Session).Init(c2, false, serverOptions)
+}
+
+func readAll(stream *Stream) ([]byte, error) {
+	var data []byte
+	buffer := make([]byte, 4096)
+
+	for {
+		n, err := stream.Read(context.Background(), time.Now().Add(time.Second), buffer)
+		data = append(data, buffer[:n]...)
+
+		if err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+
+			return data, err
+		}
+	}
+}
+
+func readFull(stream *Stream, buffer []byte) (int, error) {
+	dataSize := 0
+
+	for dataSize < len(buffer) {
+		n, err := stream.Read(context.Background(), time.Now().Add(time.Second), buffer[dataSize:])
+		dataSize += n
+
+		if err != nil {
+			return dataSize, err
+		}
+	}
+
+	return dataSize, nil
+}
@@ -0,0 +1,277 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/let-z-go/toolkit/bytestream"
+	"github.com/let-z-go/toolkit/condition"
+	"github.com/let-z-go/toolkit/connection"
+)
+
+type Stream struct {
+	session        *Session
+	id             uint32
+	lock           sync.Mutex
+	readCondition  condition.Condition
+	writeCondition condition.Condition
+	readBuffer     bytestream.ByteStream
+	receiveWindow  int
+	consumedSize   int
+	sendWindow     int
+	finIsReceived  bool
+	finIsSent      bool
+	err            error
+}
+
+func (s *Stream) init(session *Session, id uint32) *Stream {
+	s.session = session
+	s.id = id
+	s.readCondition.Init(&s.lock)
+	s.writeCondition.Init(&s.lock)
+	s.receiveWindow = session.options.InitialWindowSize
+	s.sendWindow = defaultInitialWindowSize
+	return s
+}
+
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+// Read returns io.EOF once the peer has closed the stream and all the data
+// has been read.
+func (s *Stream) Read(ctx context.Context, deadline time.Time, buffer []byte) (int, error) {
+	ctx2, cancel := withDeadline(ctx, deadline)
+	defer cancel()
+	s.lock.Lock()
+
+	for s.readBuffer.GetDataSize() == 0 {
+		if s.err != nil {
+			s.lock.Unlock()
+			return 0, s.err
+		}
+
+		if s.finIsReceived {
+			s.lock.Unlock()
+			return 0, io.EOF
+		}
+
+		if _, err := s.readCondition.WaitFor(ctx2); err != nil {
+			s.lock.Unlock()
+			return 0, convertWaitError(ctx, err)
+		}
+	}
+
+	n := s.readBuffer.Read(buffer)
+	s.consumedSize += n
+	increment := 0
+
+	if s.consumedSize >= s.session.options.InitialWindowSize/2 && !s.finIsReceived && s.err == nil {
+		increment = s.consumedSize
+		s.receiveWindow += increment
+		s.consumedSize = 0
+	}
+
+	s.lock.Unlock()
+
+	if increment >= 1 {
+		s.session.updateWindow(s.id, increment)
+	}
+
+	return n, nil
+}
+
+// Write returns once the data has been queued; the amount of queued data is
+// bounded by the flow-control window of the stream.
+func (s *Stream) Write(ctx context.Context, deadline time.Time, data []byte) (int, error) {
+	ctx2, cancel := withDeadline(ctx, deadline)
+	defer cancel()
+	dataSize := 0
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for dataSize < len(data) {
+		for s.sendWindow == 0 && s.err == nil && !s.finIsSent {
+			if _, err := s.writeCondition.WaitFor(ctx2); err != nil {
+				return dataSize, convertWaitError(ctx, err)
+			}
+		}
+
+		if s.err != nil {
+			return dataSize, s.err
+		}
+
+		if s.finIsSent {
+			return dataSize, ErrStreamClosed
+		}
+
+		chunkSize := len(data) - dataSize
+
+		if chunkSize > s.sendWindow {
+			chunkSize = s.sendWindow
+		}
+
+		if chunkSize > s.session.options.MaxFrameSize {
+			chunkSize = s.session.options.MaxFrameSize
+		}
+
+		if err := s.session.enqueueFrame(&outgoingFrame{
+			Type:     frameData,
+			StreamID: s.id,
+			Body:     append([]byte(nil), data[dataSize:dataSize+chunkSize]...),
+		}); err != nil {
+			return dataSize, err
+		}
+
+		s.sendWindow -= chunkSize
+		dataSize += chunkSize
+	}
+
+	return dataSize, nil
+}
+
+// Close closes the write side of the stream and waits for the queued data to
+// be written. The stream is released once both sides have closed it.
+func (s *Stream) Close(ctx context.Context) error {
+	s.lock.Lock()
+
+	if s.err != nil {
+		s.lock.Unlock()
+		return s.err
+	}
+
+	if s.finIsSent {
+		s.lock.Unlock()
+		return ErrStreamClosed
+	}
+
+	s.finIsSent = true
+	done := make(chan error, 1)
+	err := s.session.enqueueFrame(&outgoingFrame{
+		Type:     frameData,
+		Flags:    flagFIN,
+		StreamID: s.id,
+		Done:     done,
+	})
+
+	s.writeCondition.Broadcast()
+	isFinished := s.finIsReceived
+	s.lock.Unlock()
+
+	if isFinished {
+		s.session.removeStream(s.id)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reset aborts the stream in both directions.
+func (s *Stream) Reset() error {
+	s.lock.Lock()
+
+	if s.err != nil || (s.finIsSent && s.finIsReceived) {
+		s.lock.Unlock()
+		return ErrStreamClosed
+	}
+
+	s.setErr(ErrStreamClosed)
+	s.lock.Unlock()
+	s.session.removeStream(s.id)
+	return s.session.resetStream(s.id)
+}
+
+func (s *Stream) onData(flags uint8, data []byte) error {
+	s.lock.Lock()
+
+	if len(data) > s.receiveWindow || (s.finIsReceived && len(data) >= 1) {
+		s.lock.Unlock()
+		return ErrProtocolError
+	}
+
+	s.receiveWindow -= len(data)
+
+	if s.err == nil {
+		s.readBuffer.Write(data)
+	}
+
+	if flags&flagFIN != 0 {
+		s.finIsReceived = true
+	}
+
+	if flags&flagRST != 0 {
+		s.setErr(ErrStreamReset)
+	}
+
+	s.readCondition.Broadcast()
+	isFinished := flags&flagRST != 0 || (s.finIsReceived && s.finIsSent)
+	s.lock.Unlock()
+
+	if isFinished {
+		s.session.removeStream(s.id)
+	}
+
+	return nil
+}
+
+func (s *Stream) onWindowUpdate(increment int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if increment > maxWindowSize-s.sendWindow {
+		return ErrProtocolError
+	}
+
+	s.sendWindow += increment
+	s.writeCondition.Broadcast()
+	return nil
+}
+
+func (s *Stream) abort(err error) {
+	s.lock.Lock()
+	s.setErr(err)
+	s.lock.Unlock()
+}
+
+func (s *Stream) setErr(err error) {
+	if s.err != nil {
+		return
+	}
+
+	s.err = err
+	s.readCondition.Broadcast()
+	s.writeCondition.Broadcast()
+}
+
+var (
+	ErrStreamClosed  = errors.New("toolkit/mux: stream closed")
+	ErrStreamReset   = errors.New("toolkit/mux: stream reset")
+	ErrStreamRefused = errors.New("toolkit/mux: stream refused")
+)
+
+func withDeadline(ctx context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return ctx, func() {}
+	}
+
+	return context.WithDeadline(ctx, deadline)
+}
+
+func convertWaitError(ctx context.Context, err error) error {
+	if err == context.DeadlineExceeded && ctx.Err() == nil {
+		return connection.ErrTimedOut
+	}
+
+	return err
+}